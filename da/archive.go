@@ -0,0 +1,131 @@
+package da
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// archiveOption is the youtube-dl option naming the download archive. A DA
+// setting it in Parameters manages its own archive.
+const archiveOption = "--download-archive"
+
+// ArchiveEntry marks an item as downloaded for a DA so later runs skip it.
+// Extractor and VideoID are the two words youtube-dl writes to its
+// --download-archive file. RemovedAt is set once retention removed the item,
+// the entry stays so it is not downloaded again.
+type ArchiveEntry struct {
+	DA        string `gorm:"primary_key"`
+	Extractor string `gorm:"primary_key"`
+	VideoID   string `gorm:"primary_key"`
+	CreatedAt time.Time
+	RemovedAt *time.Time `json:",omitempty"`
+}
+
+func (a ArchiveEntry) String() string {
+	return a.Extractor + " " + a.VideoID
+}
+
+// parseArchiveLine splits a --download-archive line into extractor and id.
+func parseArchiveLine(line string) (extractor, id string, ok bool) {
+	f := strings.Fields(line)
+	if len(f) != 2 {
+		return "", "", false
+	}
+	return strings.ToLower(f[0]), f[1], true
+}
+
+// readArchive returns the entries of the --download-archive file at path.
+func readArchive(path string) (map[string]bool, error) {
+	entries := make(map[string]bool)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if extractor, id, ok := parseArchiveLine(s.Text()); ok {
+			entries[extractor+" "+id] = true
+		}
+	}
+	return entries, s.Err()
+}
+
+// appendArchive records extractor and id in the --download-archive file at
+// path.
+func appendArchive(path, extractor, id string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s %s\n", strings.ToLower(extractor), id)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// archivePath is where the archive file of da is written before a run.
+func (d *downloader) archivePath(da *DA) string {
+	return filepath.Join(d.Home, ".archive", da.ID+".txt")
+}
+
+// prepareArchive writes the archive of da stored in the database to the file
+// the backend reads, so the database stays the source of truth and reset or
+// prune take effect on the next run.
+func (d *downloader) prepareArchive(db *gorm.DB, da *DA) (string, error) {
+	entries := []ArchiveEntry{}
+	if err := db.Where(ArchiveEntry{DA: da.ID}).Find(&entries).Error; err != nil {
+		return "", err
+	}
+	path := d.archivePath(da)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		fmt.Fprintln(w, e.String())
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return path, err
+}
+
+// syncArchive stores the entries the backend added to the archive file.
+func (d *downloader) syncArchive(db *gorm.DB, da *DA, path string) (int, error) {
+	entries, err := readArchive(path)
+	if err != nil {
+		return 0, err
+	}
+	known := []ArchiveEntry{}
+	if err := db.Where(ArchiveEntry{DA: da.ID}).Find(&known).Error; err != nil {
+		return 0, err
+	}
+	for _, e := range known {
+		delete(entries, e.String())
+	}
+	added := 0
+	for line := range entries {
+		extractor, id, _ := parseArchiveLine(line)
+		if err := db.Create(&ArchiveEntry{DA: da.ID, Extractor: extractor, VideoID: id}).Error; err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
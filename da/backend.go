@@ -0,0 +1,117 @@
+package da
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrUnknownBackend = errors.New("Backend is not configured on this server")
+
+// Backend fetches the media a DA points at.
+type Backend interface {
+	// Name is the value DA.Backend and the provider mapping refer to.
+	Name() string
+	// Version reports the version of the tool behind the backend.
+	Version(ctx context.Context) (string, error)
+	// Download runs job until it finishes or ctx is cancelled, reporting
+	// progress through job.Emit.
+	Download(ctx context.Context, job *Job) error
+}
+
+// Job is a single run of a DA handed to a Backend.
+type Job struct {
+	DA  *DA
+	URL string
+	// Items, when set, are downloaded instead of URL.
+	Items []string
+	// Options are the youtube-dl style command line options of the run,
+	// the server defaults overridden by DA.Parameters.
+	Options map[string]string
+	// Output is the youtube-dl output template files are written to.
+	Output string
+	// DateAfter skips items uploaded before it.
+	DateAfter time.Time
+	// Archive is a youtube-dl --download-archive file, items listed in it
+	// are skipped and downloaded ones are appended. Empty disables it.
+	Archive string
+	Logger  *logrus.Entry
+	Emit    func(Event)
+}
+
+type EventKind int
+
+const (
+	// ItemCompleted is sent after a file has been written.
+	ItemCompleted EventKind = iota
+	// ItemOutOfRange is sent for an item skipped because of DateAfter.
+	ItemOutOfRange
+	// ItemInfo is sent when the info.json of an item has been written.
+	ItemInfo
+	// ItemError is sent with the message of an item that failed.
+	ItemError
+)
+
+// Event is reported by a Backend while a Job runs.
+type Event struct {
+	Kind  EventKind
+	Bytes int64
+	Line  string
+	// Path is the info.json of ItemInfo, or the file of ItemCompleted when
+	// the backend knows it.
+	Path string
+	// Date is the upload date of the item of ItemCompleted when the backend
+	// knows it without an info.json.
+	Date time.Time
+}
+
+// FakeBackend is a Backend for tests. It emits Events, waits Delay and
+// returns Err, recording every job it was given.
+type FakeBackend struct {
+	BackendName string
+	Events      []Event
+	Delay       time.Duration
+	Err         error
+
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+func (f *FakeBackend) Name() string {
+	if f.BackendName == "" {
+		return "fake"
+	}
+	return f.BackendName
+}
+
+func (f *FakeBackend) Version(ctx context.Context) (string, error) {
+	return "fake", nil
+}
+
+func (f *FakeBackend) Download(ctx context.Context, job *Job) error {
+	f.mu.Lock()
+	f.jobs = append(f.jobs, job)
+	f.mu.Unlock()
+	for _, e := range f.Events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		job.Emit(e)
+	}
+	select {
+	case <-time.After(f.Delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return f.Err
+}
+
+// Jobs returns the jobs Download has been called with.
+func (f *FakeBackend) Jobs() []*Job {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*Job(nil), f.jobs...)
+}
@@ -0,0 +1,36 @@
+package da
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFakeBackend(t *testing.T) {
+	errFailed := errors.New("failed")
+	f := &FakeBackend{Events: []Event{{Kind: ItemCompleted, Bytes: 10}, {Kind: ItemError, Line: "gone"}}, Err: errFailed}
+	var got []Event
+	job := &Job{URL: "https://example.com/a", Emit: func(e Event) { got = append(got, e) }}
+	if err := f.Download(context.Background(), job); err != errFailed {
+		t.Fatalf("Download() = %v, want %v", err, errFailed)
+	}
+	if len(got) != 2 || got[0].Bytes != 10 || got[1].Line != "gone" {
+		t.Errorf("emitted %+v", got)
+	}
+	if jobs := f.Jobs(); len(jobs) != 1 || jobs[0] != job {
+		t.Errorf("Jobs() = %v", jobs)
+	}
+	if f.Name() != "fake" {
+		t.Errorf("Name() = %q", f.Name())
+	}
+}
+
+func TestFakeBackendCancel(t *testing.T) {
+	f := &FakeBackend{Delay: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := f.Download(ctx, &Job{Emit: func(Event) {}}); err != context.Canceled {
+		t.Fatalf("Download() = %v, want %v", err, context.Canceled)
+	}
+}
@@ -1,25 +1,44 @@
 package da
 
 import (
-	"bufio"
+	"context"
 	"errors"
-	"os/exec"
-	"path/filepath"
+	"fmt"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
 	"github.com/sirupsen/logrus"
+	"github.com/will7200/mda/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	pdefault          map[string]string
-	youtubeDL         = []string{"youtube-dl"}
-	queue             map[string]*DA
+	queue             map[string]*JobStatus
+	queueMu           sync.Mutex
+	cleaning          map[string]bool
 	ErrAlreadyInQueue = errors.New("DA is currently in queue, Please wait until finished")
 	timeFormat        = "20060102"
 )
 
+const (
+	DefaultBackend = "youtube-dl"
+	// DefaultStopAfter is how many consecutive out of range items end a run
+	// unless WithStopAfter or DA.StopAfter say otherwise.
+	DefaultStopAfter = 10
+)
+
+// DefaultRetry is used unless WithRetry or DA.Retry say otherwise. It only
+// tries once, retries are turned on by raising MaxAttempts.
+var DefaultRetry = RetryPolicy{MaxAttempts: 1, Base: Duration(30 * time.Second), Cap: Duration(10 * time.Minute)}
+
 func init() {
 	pdefault = make(map[string]string)
 	pdefault["-f"] = "mp4"
@@ -27,104 +46,673 @@ func init() {
 	pdefault["--audio-format"] = "m4a"
 	pdefault["--audio-quality"] = "9"
 	pdefault["--embed-thumbnail"] = ""
-	queue = make(map[string]*DA)
+	pdefault["--write-info-json"] = ""
+	queue = make(map[string]*JobStatus)
+	cleaning = make(map[string]bool)
 }
 
 type Downloader interface {
-	YoutubeDL(url string, parameters Metadata, da *DA)
-	Add(da *DA) error
+	Add(ctx context.Context, da *DA) error
+	// AddItems queues a run of da that only downloads items, the URLs of
+	// item results.
+	AddItems(ctx context.Context, da *DA, items []string) error
+	Status() Status
+	// Backends returns the configured backends, the default one first.
+	Backends() []Backend
+	// HasStorage reports whether DAs may upload to the storage name.
+	HasStorage(name string) bool
+	// Clean enforces the retention rules of da.
+	Clean(ctx context.Context, da *DA, dryRun bool) (*RetentionReport, error)
+	// Janitor enforces the retention rules of every DA and the quota.
+	Janitor(ctx context.Context, dryRun bool) ([]*RetentionReport, error)
+}
+
+// JobStatus describes a DA that is waiting for or holding a download slot.
+type JobStatus struct {
+	ID       string
+	Provider string
+	URL      string
+	Running  bool
+	QueuedAt time.Time
+	Started  *time.Time `json:",omitempty"`
+	Attempt  int
+	RetryAt  *time.Time `json:",omitempty"`
+
+	// wake ends the wait before a retry early.
+	wake chan struct{}
+}
+
+// Status is a snapshot of the jobs the Downloader knows about.
+type Status struct {
+	Queued  int
+	Running int
+	Jobs    []JobStatus
 }
 
 type downloader struct {
 	Home string
 	p    map[string]string
 	db   *gorm.DB
+
+	limits   map[string]int
+	defLimit int
+	slotsMu  sync.Mutex
+	slots    map[string]chan struct{}
+	metrics  Metrics
+
+	backends         map[string]Backend
+	defaultBackend   string
+	providerBackends map[string]string
+	providers        *Registry
+	stopAfter        int
+	retry            RetryPolicy
+	post             *postprocessor
+	layout           Layout
+	sinks            map[string]sink
+	retention        Retention
+	quota            Size
+	minFree          Size
+	ctx              context.Context
+}
+
+// Option configures optional behaviour of the Downloader.
+type Option func(*downloader)
+
+// WithConcurrency limits how many jobs of a provider (DA.Location) run at
+// the same time. Providers missing from limits get def, 0 is unlimited.
+func WithConcurrency(limits map[string]int, def int) Option {
+	return func(d *downloader) {
+		for provider, n := range limits {
+			d.limits[strings.ToLower(provider)] = n
+		}
+		d.defLimit = def
+	}
+}
+
+// WithBackend makes b available to DAs, replacing a backend of the same name.
+func WithBackend(b Backend) Option {
+	return func(d *downloader) {
+		d.backends[b.Name()] = b
+	}
+}
+
+// WithDefaultBackend selects the backend used when neither the DA nor its
+// provider picks one.
+func WithDefaultBackend(name string) Option {
+	return func(d *downloader) {
+		if name != "" {
+			d.defaultBackend = name
+		}
+	}
+}
+
+// WithProviderBackends maps providers (DA.Location) to backend names.
+func WithProviderBackends(m map[string]string) Option {
+	return func(d *downloader) {
+		for provider, name := range m {
+			d.providerBackends[strings.ToLower(provider)] = name
+		}
+	}
+}
+
+// WithProviders replaces the default provider registry.
+func WithProviders(r *Registry) Option {
+	return func(d *downloader) {
+		d.providers = r
+	}
+}
+
+// WithStopAfter sets how many consecutive out of range items end a run for
+// DAs that leave StopAfter at 0. A negative n, here or in DA.StopAfter,
+// never stops early.
+func WithStopAfter(n int) Option {
+	return func(d *downloader) {
+		if n != 0 {
+			d.stopAfter = n
+		}
+	}
+}
+
+// WithRetry sets the retry policy of DAs that do not have their own.
+func WithRetry(p RetryPolicy) Option {
+	return func(d *downloader) {
+		d.retry = p
+	}
+}
+
+// WithFFmpeg sets the ffmpeg binary post processing steps run.
+func WithFFmpeg(binary string) Option {
+	return func(d *downloader) {
+		if binary != "" {
+			d.post.ffmpeg = binary
+		}
+	}
+}
+
+// WithContext cancels running and waiting jobs once ctx is done, for
+// shutting down.
+func WithContext(ctx context.Context) Option {
+	return func(d *downloader) {
+		d.ctx = ctx
+	}
+}
+
+// runContext is cancelled with the downloader but keeps the values, request
+// id and span, of the context a job was added with.
+type runContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c runContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+// WithLayout sets the layout of DAs that do not override it.
+func WithLayout(l Layout) Option {
+	return func(d *downloader) {
+		d.layout = l
+	}
 }
 
-func NewDownloader(home string, db *gorm.DB) Downloader {
+// NewDownloader returns a Downloader writing into home. youtube-dl, yt-dlp
+// and http backends are available unless replaced with WithBackend.
+func NewDownloader(home string, db *gorm.DB, opts ...Option) Downloader {
 	def := make(map[string]string)
 	for index, value := range pdefault {
 		def[index] = value
 	}
-	def["-o"] = home + filepath.Join("%(playlist)s", "%(upload_date)s", "%(id)s__%(title)s.%(ext)s")
-	return downloader{home, def, db}
+	d := &downloader{Home: home, p: def, db: db,
+		limits: make(map[string]int), slots: make(map[string]chan struct{}),
+		metrics:          discardMetrics(),
+		backends:         make(map[string]Backend),
+		defaultBackend:   DefaultBackend,
+		providerBackends: make(map[string]string),
+		providers:        DefaultProviders(),
+		stopAfter:        DefaultStopAfter,
+		retry:            DefaultRetry,
+		post:             &postprocessor{ffmpeg: "ffmpeg"},
+		sinks:            make(map[string]sink),
+		minFree:          DefaultMinFree,
+		ctx:              context.Background()}
+	for _, b := range []Backend{NewYoutubeDL(""), NewYtDlp(""), NewHTTPBackend(nil)} {
+		d.backends[b.Name()] = b
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Add queues da, the download runs in the background with a context that
+// keeps the request id and span of ctx.
+func (d *downloader) Add(ctx context.Context, da *DA) error {
+	return d.AddItems(ctx, da, nil)
 }
 
-func (d downloader) Add(da *DA) error {
-	if _, ok := queue[da.ID]; ok {
-		logrus.Debug("Not adding already in queue")
+// AddItems queues a run of da that only downloads items, or all of da when
+// there are none. A DA waiting to be retried is started right away instead,
+// with the items of the run it is retrying.
+func (d *downloader) AddItems(ctx context.Context, da *DA, items []string) error {
+	if err := d.checkFree(logrus.WithFields(logrus.Fields{"da": da.ID, "name": da.Name})); err != nil {
+		return err
+	}
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	if cleaning[da.ID] {
+		return ErrCleaning
+	}
+	if j, ok := queue[da.ID]; ok {
+		if j.RetryAt != nil {
+			select {
+			case j.wake <- struct{}{}:
+			default:
+			}
+			j.RetryAt = nil
+			return nil
+		}
+		logrus.WithFields(logrus.Fields{"da": da.ID, "name": da.Name, "request_id": tracing.RequestID(ctx)}).
+			Debug("Not adding already in queue")
 		return ErrAlreadyInQueue
 	}
-	queue[da.ID] = da
-	go d.YoutubeDL(da.URL, da.Parameters, da)
+	ctx = runContext{d.ctx, tracing.Detach(ctx)}
+	provider := providerOf(da)
+	wake := make(chan struct{}, 1)
+	queue[da.ID] = &JobStatus{ID: da.ID, Provider: provider, URL: da.URL, QueuedAt: time.Now(), wake: wake}
+	d.metrics.Queued.With("provider", provider).Add(1)
+	go func() {
+		defer dequeue(da.ID)
+		policy := d.retryFor(da)
+		run := uuid.NewV4().String()
+		for attempt := 1; ; attempt++ {
+			err := d.attempt(ctx, da, items, newStats(da.ID, run, attempt))
+			if !policy.Retry(attempt, err) {
+				return
+			}
+			wait := policy.Backoff(attempt)
+			logrus.WithFields(logrus.Fields{"da": da.ID, "name": da.Name, "run": run, "attempt": attempt, "wait": wait.String()}).
+				WithError(err).Info("Retrying after transient error")
+			markWaiting(da.ID, time.Now().Add(wait))
+			d.metrics.Queued.With("provider", provider).Add(1)
+			select {
+			case <-time.After(wait):
+			case <-wake:
+			case <-ctx.Done():
+				d.metrics.Queued.With("provider", provider).Add(-1)
+				return
+			}
+		}
+	}()
 	return nil
 }
-func (d downloader) YoutubeDL(url string, parameters Metadata, da *DA) {
-	args := make([]string, 0)
-	args = append(args, youtubeDL...)
-	args = append(args, url)
-	v := combineMap(d.p, parameters)
-	for index, value := range v {
-		if value != "" {
-			args = append(args, index, value)
+
+// attempt runs da once it gets a download slot.
+func (d *downloader) attempt(ctx context.Context, da *DA, items []string, stats *Stats) error {
+	provider := providerOf(da)
+	release := d.acquire(provider)
+	defer release()
+	markRunning(da.ID, stats.Attempt)
+	d.metrics.Queued.With("provider", provider).Add(-1)
+	d.metrics.Running.With("provider", provider).Add(1)
+	defer d.metrics.Running.With("provider", provider).Add(-1)
+	return d.run(ctx, da, items, stats)
+}
+
+// retryFor is the policy of da, its unset fields taken from the default.
+func (d *downloader) retryFor(da *DA) RetryPolicy {
+	if da.Retry == nil {
+		return d.retry
+	}
+	p := *da.Retry
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = d.retry.MaxAttempts
+	}
+	if p.Base == 0 {
+		p.Base = d.retry.Base
+	}
+	if p.Cap == 0 {
+		p.Cap = d.retry.Cap
+	}
+	return p
+}
+
+// acquire blocks until provider has a free slot and returns the func that
+// gives it back.
+func (d *downloader) acquire(provider string) func() {
+	n, ok := d.limits[provider]
+	if !ok {
+		n = d.defLimit
+	}
+	if n <= 0 {
+		return func() {}
+	}
+	d.slotsMu.Lock()
+	slot, ok := d.slots[provider]
+	if !ok {
+		slot = make(chan struct{}, n)
+		d.slots[provider] = slot
+	}
+	d.slotsMu.Unlock()
+	slot <- struct{}{}
+	return func() { <-slot }
+}
+
+func markRunning(id string, attempt int) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	if j, ok := queue[id]; ok {
+		t := time.Now()
+		j.Running = true
+		j.Started = &t
+		j.Attempt = attempt
+		j.RetryAt = nil
+	}
+}
+
+func markWaiting(id string, at time.Time) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	if j, ok := queue[id]; ok {
+		j.Running = false
+		j.RetryAt = &at
+	}
+}
+
+func dequeue(id string) {
+	queueMu.Lock()
+	delete(queue, id)
+	queueMu.Unlock()
+}
+
+func (d *downloader) Status() Status {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	s := Status{Jobs: make([]JobStatus, 0, len(queue))}
+	for _, j := range queue {
+		if j.Running {
+			s.Running++
 		} else {
-			args = append(args, index)
+			s.Queued++
 		}
+		s.Jobs = append(s.Jobs, *j)
 	}
-	if da.Currentdate.Before(*da.Startdate) {
-		args = append(args, "--dateafter", da.Startdate.Format(timeFormat))
-	} else {
-		args = append(args, "--dateafter", da.Currentdate.Format(timeFormat))
+	sort.Slice(s.Jobs, func(i, k int) bool { return s.Jobs[i].QueuedAt.Before(s.Jobs[k].QueuedAt) })
+	return s
+}
+
+func (d *downloader) Backends() []Backend {
+	backends := make([]Backend, 0, len(d.backends))
+	for _, b := range d.backends {
+		backends = append(backends, b)
 	}
-	cmd := exec.Command(args[0], args[1:]...)
-	logrus.Debug("Command executing with ", args)
-	stdpipe, err := cmd.StdoutPipe()
+	sort.Slice(backends, func(i, k int) bool {
+		if backends[i].Name() == d.defaultBackend {
+			return true
+		}
+		if backends[k].Name() == d.defaultBackend {
+			return false
+		}
+		return backends[i].Name() < backends[k].Name()
+	})
+	return backends
+}
+
+// backendFor picks the backend named by da, then the one configured for its
+// provider, then the one the provider itself asks for, then the default one.
+func (d *downloader) backendFor(da *DA) (Backend, error) {
+	name := da.Backend
+	if name == "" {
+		name = d.providerBackends[providerOf(da)]
+	}
+	if name == "" {
+		if p, ok := d.providers.Get(da.Location); ok {
+			name = p.Backend
+		}
+	}
+	if name == "" {
+		name = d.defaultBackend
+	}
+	b, ok := d.backends[name]
+	if !ok {
+		return nil, ErrUnknownBackend
+	}
+	return b, nil
+}
+
+// run downloads da, or only its items when given, with its backend, records
+// the session in stats and returns why it failed.
+func (d *downloader) run(ctx context.Context, da *DA, only []string, stats *Stats) error {
+	provider := providerOf(da)
+	started := time.Now()
+	var items, bytes int64
+	logger := logrus.WithFields(logrus.Fields{
+		"da":         da.ID,
+		"name":       da.Name,
+		"session":    stats.Session,
+		"run":        stats.Run,
+		"attempt":    stats.Attempt,
+		"request_id": tracing.RequestID(ctx),
+		"provider":   provider,
+	})
+	ctx, span := tracing.Tracer().Start(ctx, "download", trace.WithAttributes(
+		attribute.String("mda.id", da.ID),
+		attribute.String("mda.session", stats.Session),
+		attribute.String("mda.run", stats.Run),
+		attribute.Int("mda.attempt", stats.Attempt),
+		attribute.String("mda.provider", provider),
+		attribute.String("mda.url", da.URL),
+	))
+	db := tracing.DB(ctx, d.db)
+	defer func() {
+		n, b := atomic.LoadInt64(&items), atomic.LoadInt64(&bytes)
+		span.SetAttributes(attribute.Int64("mda.items", n), attribute.Int64("mda.bytes", b))
+		if stats.Success {
+			d.metrics.Succeeded.With("provider", provider).Add(1)
+		} else {
+			d.metrics.Failed.With("provider", provider).Add(1)
+			span.SetStatus(codes.Error, stats.Error)
+		}
+		d.metrics.Bytes.With("provider", provider).Add(float64(b))
+		d.metrics.Items.With("provider", provider).Observe(float64(n))
+		d.metrics.Duration.With("provider", provider).Observe(time.Since(started).Seconds())
+		span.End()
+		logger.WithFields(logrus.Fields{
+			"items":    n,
+			"bytes":    b,
+			"success":  stats.Success,
+			"duration": time.Since(started).String(),
+		}).Info("Session finished")
+		db.Create(stats)
+	}()
+	backend, err := d.backendFor(da)
 	if err != nil {
-		logrus.Info("Cannot open pipe")
-		return
+		logger.WithError(err).WithField("backend", da.Backend).Error("Could not continue with job")
+		stats.Success = false
+		stats.Error = err.Error()
+		stats.ErrorClass = ErrorUnknown
+		return err
 	}
-	stats := newStats(da.ID)
-	done := make(chan error)
-	notinrange := make(chan bool)
+	span.SetAttributes(attribute.String("mda.backend", backend.Name()))
+	logger = logger.WithField("backend", backend.Name())
 
-	err = cmd.Start()
+	options := d.p
+	if p, ok := d.providers.Get(da.Location); ok {
+		options = combineMap(options, p.Options)
+	}
+	options = combineMap(options, da.Parameters)
+	layout := d.layout.merge(da.Layout)
+	output, err := layout.output(d.Home, options["-o"])
 	if err != nil {
-		logrus.Info("Process exiting with error ", err)
-		logrus.Info("Could not continue with job")
-		logrus.Debug("This will not be logged")
-		delete(queue, da.ID)
-		return
+		logger.WithError(err).Error("Could not continue with job")
+		stats.Success = false
+		stats.Error = err.Error()
+		stats.ErrorClass = ErrorUnknown
+		return err
 	}
-	//file, _ = os.Open("log_mjs.txt")
-	fs := bufio.NewScanner(stdpipe)
-	go func() {
-		for fs.Scan() {
-			t := fs.Text()
-			//fmt.Println(t)
-			if strings.Contains(t, "not in range") {
-				notinrange <- true
+	if err := d.checkFree(logger); err != nil {
+		logger.WithError(err).Error("Could not continue with job")
+		stats.Success = false
+		stats.Error = err.Error()
+		stats.ErrorClass = ErrorDiskFull
+		return err
+	}
+	delete(options, "-o")
+	if layout.ASCII {
+		options["--restrict-filenames"] = ""
+	}
+	dateafter := *da.Currentdate
+	if da.Currentdate.Before(*da.Startdate) {
+		dateafter = *da.Startdate
+	}
+	if len(only) > 0 {
+		dateafter = time.Time{}
+	}
+	var archive string
+	if _, ok := options[archiveOption]; !ok {
+		if archive, err = d.prepareArchive(db, da); err != nil {
+			logger.WithError(err).Warn("Could not prepare download archive, running without it")
+			archive = ""
+		}
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopAfter := int32(d.stopAfter)
+	if da.StopAfter != 0 {
+		stopAfter = int32(da.StopAfter)
+	}
+	var outOfRange, stopped int32
+	var mu sync.Mutex
+	var infos, failures []string
+	var files []Event
+	job := &Job{
+		DA:        da,
+		URL:       da.URL,
+		Items:     only,
+		Options:   options,
+		Output:    output,
+		DateAfter: dateafter,
+		Archive:   archive,
+		Logger:    logger,
+		Emit: func(e Event) {
+			switch e.Kind {
+			case ItemCompleted:
+				atomic.AddInt64(&items, 1)
+				atomic.AddInt64(&bytes, e.Bytes)
+				atomic.StoreInt32(&outOfRange, 0)
+				if e.Path != "" {
+					mu.Lock()
+					files = append(files, e)
+					mu.Unlock()
+				}
+			case ItemInfo:
+				atomic.StoreInt32(&outOfRange, 0)
+				mu.Lock()
+				infos = append(infos, e.Path)
+				mu.Unlock()
+			case ItemError:
+				mu.Lock()
+				failures = append(failures, e.Line)
+				mu.Unlock()
+			case ItemOutOfRange:
+				n := atomic.AddInt32(&outOfRange, 1)
+				if stopAfter > 0 && n >= stopAfter && atomic.CompareAndSwapInt32(&stopped, 0, 1) {
+					logger.WithField("out_of_range", n).Debug("Reached consecutive items not in range, stopping")
+					cancel()
+				}
 			}
+		},
+	}
+	err = backend.Download(jobCtx, job)
+	results, newest := d.index(ctx, db, logger, da, stats.Session, infos, files, failures)
+	if err := saveResults(db, stats, results); err != nil {
+		logger.WithError(err).Warn("Could not record item results")
+	}
+	if archive != "" {
+		if n, err := d.syncArchive(db, da, archive); err != nil {
+			logger.WithError(err).Warn("Could not record download archive")
+		} else {
+			logger.WithField("archived", n).Debug("Recorded download archive")
 		}
-	}()
-	go func() { done <- cmd.Wait() }()
-	select {
-	case err := <-done:
-		logrus.Info("Process exiting with error ", err)
+	}
+	if atomic.LoadInt32(&stopped) == 1 {
+		err = nil
+	}
+	if err != nil {
+		stats.Success = false
+		stats.Error = err.Error()
+		stats.ErrorClass, stats.ErrorItem = Classify(err)
+		logger.WithError(err).WithFields(logrus.Fields{"class": stats.ErrorClass, "item": stats.ErrorItem}).
+			Warn("Download failed")
+		return err
+	}
+	if failed := failedResults(results); failed > 0 {
+		logger.WithField("failed", failed).Info("Items failed, keeping the watermark so they are picked up again")
+		return nil
+	}
+	d.advance(db, logger, da, newest)
+	return nil
+}
+
+// failedResults counts the items of results that failed.
+func failedResults(results []*ItemResult) (n int) {
+	for _, r := range results {
+		if r.Status == ItemFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// advance moves the watermark of da to newest, the upload date of the newest
+// item a successful run downloaded. It never moves backwards and a run that
+// downloaded nothing leaves it alone, youtube-dl's --dateafter is inclusive
+// so items uploaded later that day are still picked up.
+func (d *downloader) advance(db *gorm.DB, logger *logrus.Entry, da *DA, newest time.Time) {
+	if newest.IsZero() || (da.Currentdate != nil && !newest.After(*da.Currentdate)) {
+		return
+	}
+	if err := db.Model(da).UpdateColumn("currentdate", newest).Error; err != nil {
+		logger.WithError(err).Warn("Could not advance watermark")
+		return
+	}
+	logger.WithField("currentdate", newest.Format(timeFormat)).Debug("Advanced watermark")
+}
+
+// index records the items a run downloaded in the media library and returns
+// the result of every item together with the newest upload date among the
+// downloaded ones. Items that failed to download have an info.json but no
+// media file and are only reported through their failure.
+func (d *downloader) index(ctx context.Context, db *gorm.DB, logger *logrus.Entry, da *DA, session string, infos []string, files []Event, failures []string) ([]*ItemResult, time.Time) {
+	var newest time.Time
+	items := make([]*MediaItem, 0, len(infos)+len(files))
+	parsed := make(map[string]*info)
+	for _, path := range infos {
+		i, err := readInfo(path)
 		if err != nil {
-			stats.Success = false
-			stats.Error = err.Error()
-		} else {
-			*da.Currentdate = time.Now()
-			d.db.Model(da).Update(da)
+			logger.WithError(err).WithField("info", path).Debug("Not indexing item")
+			continue
+		}
+		parsed[i.ID] = i
+		m, err := mediaFromInfo(da, session, path, i)
+		if err != nil {
+			logger.WithError(err).WithField("info", path).Debug("Not indexing item")
+			continue
+		}
+		items = append(items, m)
+	}
+	for _, e := range files {
+		m, err := mediaFromFile(da, session, e.Path, e.Date)
+		if err != nil {
+			logger.WithError(err).WithField("path", e.Path).Debug("Not indexing item")
+			continue
 		}
-	case _ = <-notinrange:
-		cmd.Process.Kill()
-		*da.Currentdate = time.Now()
-		d.db.Model(da).Update(da)
+		items = append(items, m)
 	}
+	for _, m := range items {
+		steps, extra, err := d.postprocess(ctx, logger, da, m, parsed[m.VideoID])
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("[%s] %s: Postprocessing %s", m.Extractor, m.VideoID, err))
+		} else if err := d.store(ctx, da, m, extra); err != nil {
+			logger.WithError(err).WithFields(logrus.Fields{"item": m.VideoID, "storage": da.Storage}).Warn("Upload failed")
+			failures = append(failures, fmt.Sprintf("[%s] %s: Upload to %s failed: %s", m.Extractor, m.VideoID, da.Storage, err))
+		}
+		if err := saveMedia(db, m); err != nil {
+			logger.WithError(err).WithField("path", m.Path).Warn("Could not index item")
+			continue
+		}
+		for _, r := range steps {
+			r.Item, r.Session = m.ID, session
+			if err := db.Create(r).Error; err != nil {
+				logger.WithError(err).WithField("step", r.Step).Warn("Could not record step result")
+			}
+		}
+		if m.UploadDate != nil && m.UploadDate.After(newest) {
+			newest = *m.UploadDate
+		}
+	}
+	return itemResults(da, session, items, parsed, failures), newest
+}
 
-	d.db.Create(stats)
-	delete(queue, da.ID)
+// postprocess runs the steps of da on m and updates it to the file they
+// leave behind, returning the other files they created.
+func (d *downloader) postprocess(ctx context.Context, logger *logrus.Entry, da *DA, m *MediaItem, i *info) ([]*StepResult, []string, error) {
+	if len(da.Steps) == 0 {
+		return nil, nil, nil
+	}
+	item := &stepItem{Path: m.Path, Info: i, Home: d.Home}
+	results, err := d.post.run(ctx, logger.WithField("item", m.VideoID), da.Steps, item)
+	if serr := m.stat(item.Path); serr != nil && err == nil {
+		err = serr
+	}
+	return results, item.Extra, err
+}
+
+// providerOf is the label metrics and concurrency limits use for da.
+func providerOf(da *DA) string {
+	if da.Location == "" {
+		return "unknown"
+	}
+	return strings.ToLower(da.Location)
 }
 
 func combineMap(a, b map[string]string) map[string]string {
@@ -0,0 +1,128 @@
+package da
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// testDB is an empty in memory database with the tables of da.
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a database of its own.
+	db.DB().SetMaxOpenConns(1)
+	CreateDatabaseTables(db)
+	return db
+}
+
+// testDA stores a DA downloading with b from a fresh home.
+func testDA(t *testing.T, db *gorm.DB, b Backend, opts ...Option) (*downloader, *DA) {
+	home, err := ioutil.TempDir("", "mda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDownloader(home, db, append([]Option{WithBackend(b)}, opts...)...).(*downloader)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	da := &DA{URL: "https://example.com/feed", Location: "http", Backend: b.Name(), Startdate: &start, Currentdate: &start}
+	if err := db.Create(da).Error; err != nil {
+		t.Fatal(err)
+	}
+	return d, da
+}
+
+func TestRunAdvance(t *testing.T) {
+	day := func(m time.Month) time.Time { return time.Date(2020, m, 1, 0, 0, 0, 0, time.UTC) }
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name   string
+		dates  []time.Time
+		failed bool
+		err    error
+		want   time.Time
+	}{
+		{"newest item", []time.Time{day(2), day(3)}, false, nil, day(3)},
+		{"nothing downloaded", nil, false, nil, day(1)},
+		{"never backwards", []time.Time{day(1)}, false, nil, day(1)},
+		{"an item failed", []time.Time{day(2), day(3)}, true, nil, day(1)},
+		{"run failed", []time.Time{day(2)}, false, errFailed, day(1)},
+	}
+	for _, tt := range tests {
+		db := testDB(t)
+		f := &FakeBackend{Err: tt.err}
+		d, da := testDA(t, db, f)
+		for i, date := range tt.dates {
+			p := filepath.Join(d.Home, "item-"+string(rune('a'+i))+".mp3")
+			if err := ioutil.WriteFile(p, []byte("mp3"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f.Events = append(f.Events, Event{Kind: ItemCompleted, Path: p, Date: date, Bytes: 3})
+		}
+		if tt.failed {
+			f.Events = append(f.Events, Event{Kind: ItemError, Line: "[generic] gone: Video unavailable"})
+		}
+		if err := d.run(context.Background(), da, nil, newStats(da.ID, "run", 1)); err != tt.err {
+			t.Errorf("%s: run() = %v, want %v", tt.name, err, tt.err)
+		}
+		got := &DA{}
+		if err := db.Where("id = ?", da.ID).First(got).Error; err != nil {
+			t.Fatal(err)
+		}
+		if got.Currentdate == nil || !got.Currentdate.Equal(tt.want) {
+			t.Errorf("%s: watermark = %v, want %v", tt.name, got.Currentdate, tt.want)
+		}
+		db.Close()
+		os.RemoveAll(d.Home)
+	}
+}
+
+func TestRunStopAfter(t *testing.T) {
+	errFinished := errors.New("backend finished")
+	outOfRange := func(n int) []Event {
+		events := make([]Event, n)
+		for i := range events {
+			events[i] = Event{Kind: ItemOutOfRange}
+		}
+		return events
+	}
+	tests := []struct {
+		name      string
+		events    []Event
+		server    int
+		stopAfter int
+		stopped   bool
+	}{
+		{"default", outOfRange(DefaultStopAfter), 0, 0, true},
+		{"server setting", outOfRange(3), 3, 0, true},
+		{"DA over server", outOfRange(3), 10, 3, true},
+		{"too few", outOfRange(2), 3, 0, false},
+		{"reset by an item", append(append(outOfRange(2), Event{Kind: ItemCompleted}), outOfRange(2)...), 3, 0, false},
+		{"never", outOfRange(20), 3, -1, false},
+	}
+	for _, tt := range tests {
+		db := testDB(t)
+		// A backend that is stopped returns the error of its context,
+		// one left to finish returns errFinished.
+		f := &FakeBackend{Events: tt.events, Delay: 10 * time.Millisecond, Err: errFinished}
+		d, da := testDA(t, db, f, WithStopAfter(tt.server))
+		da.StopAfter = tt.stopAfter
+		err := d.run(context.Background(), da, nil, newStats(da.ID, "run", 1))
+		if tt.stopped && err != nil {
+			t.Errorf("%s: run() = %v, want it stopped without error", tt.name, err)
+		}
+		if !tt.stopped && err != errFinished {
+			t.Errorf("%s: run() = %v, want the backend to finish", tt.name, err)
+		}
+		db.Close()
+		os.RemoveAll(d.Home)
+	}
+}
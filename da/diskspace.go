@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package da
+
+import "syscall"
+
+// freeSpace is the space left to unprivileged users on the volume of path.
+func freeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
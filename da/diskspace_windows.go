@@ -0,0 +1,8 @@
+package da
+
+import "errors"
+
+// freeSpace is not read on windows, runs start without checking.
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.New("free space is not supported on windows")
+}
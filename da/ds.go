@@ -18,8 +18,24 @@ type DA struct {
 	Owner       string
 	Enabled     bool
 	Parameters  Metadata `sql:"Type:bytea"`
+	Backend     string
+	Storage     string
+	StopAfter   int
 	Startdate   *time.Time
 	Currentdate *time.Time
+	// Retry overrides the server retry policy, nil uses the default.
+	Retry *RetryPolicy `sql:"Type:bytea"`
+	// Steps post process every downloaded file in order.
+	Steps Pipeline `sql:"Type:bytea"`
+	// Layout overrides the fields of the server layout it sets.
+	Layout *Layout `sql:"Type:bytea"`
+	// Retention overrides the server retention rules it sets.
+	Retention *Retention `sql:"Type:bytea"`
+	// Name is a unique slug routes accept in place of ID, and the stable
+	// key of a DA listed in the subscriptions config. Managed marks the DAs
+	// the config created or took over.
+	Name    string `sql:"unique_index"`
+	Managed bool
 }
 type Stats struct {
 	Session string `gorm:"primary_key"`
@@ -27,13 +43,27 @@ type Stats struct {
 	Success bool
 	Error   string
 	RanAt   *time.Time
+	// Run is shared by the attempts of one logical run, Attempt counts
+	// them from 1.
+	Run     string
+	Attempt int
+
+	// ErrorClass is what Error was classified as and ErrorItem the id of
+	// the item that caused it, when known.
+	ErrorClass ErrorClass
+	ErrorItem  string
+
+	// Completed and Failed count the item results of the session.
+	Completed int
+	Failed    int
 }
 type Metadata map[string]string
 
-func newStats(id string) *Stats {
+func newStats(id, run string, attempt int) *Stats {
 	t := time.Now()
 	return &Stats{ID: id, RanAt: &t,
-		Session: uuid.NewV4().String(), Success: true}
+		Session: uuid.NewV4().String(), Success: true,
+		Run: run, Attempt: attempt}
 }
 func (d Metadata) Value() (driver.Value, error) {
 	if d == nil {
@@ -76,7 +106,18 @@ func (d *Metadata) Scan(src interface{}) error {
 }
 
 func (d *DA) BeforeCreate(scope *gorm.Scope) error {
-	scope.SetColumn("ID", uuid.NewV4().String())
+	if d.ID == "" {
+		scope.SetColumn("ID", uuid.NewV4().String())
+	} else if err := ValidateID(d.ID); err != nil {
+		return err
+	}
+	if d.Name == "" {
+		name, err := UniqueName(scope.NewDB(), NameFor(d.URL), "")
+		if err != nil {
+			return err
+		}
+		scope.SetColumn("Name", name)
+	}
 	if d.Currentdate == nil {
 		scope.SetColumn("Currentdate", time.Time{})
 	}
@@ -86,5 +127,5 @@ func (d *DA) GetDA() DA {
 	return *d
 }
 func CreateDatabaseTables(db *gorm.DB) {
-	db.AutoMigrate(&DA{}, &Stats{})
+	db.AutoMigrate(&DA{}, &Stats{}, &MediaItem{}, &ArchiveEntry{}, &ItemResult{}, &StepResult{})
 }
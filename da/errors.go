@@ -0,0 +1,107 @@
+package da
+
+import (
+	"errors"
+	"net"
+	"regexp"
+)
+
+// ErrorClass is the kind of failure a run ended with.
+type ErrorClass string
+
+const (
+	ErrorNone             ErrorClass = ""
+	ErrorVideoUnavailable ErrorClass = "video_unavailable"
+	ErrorGeoBlocked       ErrorClass = "geo_blocked"
+	ErrorPrivate          ErrorClass = "private"
+	ErrorCopyright        ErrorClass = "copyright"
+	ErrorRateLimited      ErrorClass = "rate_limited"
+	ErrorExtractorBroken  ErrorClass = "extractor_broken"
+	ErrorNetwork          ErrorClass = "network"
+	ErrorDiskFull         ErrorClass = "disk_full"
+	ErrorPostprocessing   ErrorClass = "postprocessing"
+	ErrorUnknown          ErrorClass = "unknown"
+)
+
+// ErrorClasses lists every class a failure can be given.
+var ErrorClasses = []ErrorClass{
+	ErrorVideoUnavailable, ErrorGeoBlocked, ErrorPrivate, ErrorCopyright, ErrorRateLimited,
+	ErrorExtractorBroken, ErrorNetwork, ErrorDiskFull, ErrorPostprocessing, ErrorUnknown,
+}
+
+// Transient reports whether failures of class c may go away on their own.
+func (c ErrorClass) Transient() bool {
+	return c == ErrorRateLimited || c == ErrorNetwork
+}
+
+// classifiers are tried in order, the more specific messages first since a
+// copyright or geo block is also reported as the video being unavailable and
+// YouTube asks bots to sign in.
+var classifiers = []struct {
+	class ErrorClass
+	re    *regexp.Regexp
+}{
+	{ErrorDiskFull, regexp.MustCompile(`(?i)no space left on device|disk quota exceeded|ENOSPC`)},
+	{ErrorPostprocessing, regexp.MustCompile(`(?i)postprocessing|ffmpeg|ffprobe|avconv|conversion failed`)},
+	{ErrorCopyright, regexp.MustCompile(`(?i)copyright`)},
+	{ErrorGeoBlocked, regexp.MustCompile(`(?i)available in your country|geo.?restrict|blocked it in your country|from your location`)},
+	{ErrorRateLimited, regexp.MustCompile(`(?i)HTTP Error 429|too many requests|rate.?limit|confirm you.re not a bot`)},
+	{ErrorPrivate, regexp.MustCompile(`(?i)private video|video is private|members.?only|sign in to|login required|requires authentication`)},
+	{ErrorVideoUnavailable, regexp.MustCompile(`(?i)video unavailable|is unavailable|no longer available|has been removed|does not exist|HTTP Error 404|HTTP Error 410|account .* terminated`)},
+	{ErrorNetwork, regexp.MustCompile(`(?i)HTTP Error 5\d\d|urlopen error|timed out|connection (?:reset|refused|aborted)|temporary failure in name resolution|remote end closed connection|IncompleteRead|network is unreachable`)},
+	{ErrorExtractorBroken, regexp.MustCompile(`(?i)unable to extract|unsupported url|please report this issue|unable to download (?:webpage|video data|JSON metadata)|KeyError|no video formats found`)},
+}
+
+// itemRe finds the item a youtube-dl message is about, as in
+// "[youtube] dQw4w9WgXcQ: Video unavailable".
+var itemRe = regexp.MustCompile(`^\[[^\]]+\]\s+([^:\s]+):`)
+
+// classifyMessage classifies a single error message.
+func classifyMessage(m string) (class ErrorClass, item string) {
+	class = ErrorUnknown
+	for _, c := range classifiers {
+		if c.re.MatchString(m) {
+			class = c.class
+			break
+		}
+	}
+	if s := itemRe.FindStringSubmatch(m); s != nil {
+		item = s[1]
+	}
+	return class, item
+}
+
+// Classify returns the class of err and, when the tool named it, the id of
+// the item that failed. The last message that can be classified wins since
+// youtube-dl prints the error that ended the run last.
+func Classify(err error) (class ErrorClass, item string) {
+	if err == nil {
+		return ErrorNone, ""
+	}
+	var be *BackendError
+	if errors.As(err, &be) {
+		for i := len(be.Messages) - 1; i >= 0; i-- {
+			if class, item = classifyMessage(be.Messages[i]); class != ErrorUnknown {
+				return class, item
+			}
+		}
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return ErrorNetwork, item
+	}
+	if c, _ := classifyMessage(err.Error()); c != ErrorUnknown {
+		return c, item
+	}
+	return ErrorUnknown, item
+}
+
+// ParseErrorClass checks that s names an ErrorClass.
+func ParseErrorClass(s string) (ErrorClass, bool) {
+	for _, c := range ErrorClasses {
+		if string(c) == s {
+			return c, true
+		}
+	}
+	return ErrorNone, false
+}
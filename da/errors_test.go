@@ -0,0 +1,109 @@
+package da
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// Messages as youtube-dl and yt-dlp print them, without the "ERROR: " prefix.
+var classifyTests = []struct {
+	msg   string
+	class ErrorClass
+	item  string
+}{
+	{"[youtube] dQw4w9WgXcQ: Video unavailable", ErrorVideoUnavailable, "dQw4w9WgXcQ"},
+	{"[youtube] dQw4w9WgXcQ: Video unavailable. This video is no longer available because the YouTube account associated with this video has been terminated.", ErrorVideoUnavailable, "dQw4w9WgXcQ"},
+	{"[youtube] dQw4w9WgXcQ: This video has been removed by the uploader", ErrorVideoUnavailable, "dQw4w9WgXcQ"},
+	{"[soundcloud] 123456: Unable to download JSON metadata: HTTP Error 404: Not Found (caused by HTTPError()); please report this issue on https://yt-dl.org/bug .", ErrorVideoUnavailable, "123456"},
+	{"[youtube] dQw4w9WgXcQ: This video contains content from UMG, who has blocked it in your country on copyright grounds.", ErrorCopyright, "dQw4w9WgXcQ"},
+	{"[youtube] dQw4w9WgXcQ: Video unavailable. This video is no longer available due to a copyright claim by Some Label", ErrorCopyright, "dQw4w9WgXcQ"},
+	{"[youtube] dQw4w9WgXcQ: The uploader has not made this video available in your country.", ErrorGeoBlocked, "dQw4w9WgXcQ"},
+	{"[youtube] dQw4w9WgXcQ: Video unavailable. The uploader has not made this video available in your country", ErrorGeoBlocked, "dQw4w9WgXcQ"},
+	{"[BBC] p0abc123: This video is not available from your location due to geo restriction", ErrorGeoBlocked, "p0abc123"},
+	{"[youtube] dQw4w9WgXcQ: Private video. Sign in if you've been granted access to this video", ErrorPrivate, "dQw4w9WgXcQ"},
+	{"[youtube] dQw4w9WgXcQ: Join this channel to get access to members-only content like this video, and other exclusive perks.", ErrorPrivate, "dQw4w9WgXcQ"},
+	{"[youtube] dQw4w9WgXcQ: Sign in to confirm your age. This video may be inappropriate for some users.", ErrorPrivate, "dQw4w9WgXcQ"},
+	{"[youtube] dQw4w9WgXcQ: Sign in to confirm you’re not a bot. Use --cookies-from-browser or --cookies for the authentication.", ErrorRateLimited, "dQw4w9WgXcQ"},
+	{"[youtube] dQw4w9WgXcQ: Unable to download webpage: HTTP Error 429: Too Many Requests (caused by <HTTPError 429: 'Too Many Requests'>)", ErrorRateLimited, "dQw4w9WgXcQ"},
+	{"[youtube] dQw4w9WgXcQ: Unable to download webpage: <urlopen error [Errno -3] Temporary failure in name resolution> (caused by URLError(gaierror(-3, 'Temporary failure in name resolution')))", ErrorNetwork, "dQw4w9WgXcQ"},
+	{"[youtube:tab] UCabc: Unable to download API page: HTTP Error 503: Service Unavailable (caused by <HTTPError 503: 'Service Unavailable'>)", ErrorNetwork, "UCabc"},
+	{"unable to download video data: <urlopen error [Errno 104] Connection reset by peer>", ErrorNetwork, ""},
+	{"('Connection broken: IncompleteRead(0 bytes read, 1024 more expected)', IncompleteRead(0 bytes read, 1024 more expected))", ErrorNetwork, ""},
+	{"[vimeo] 76979871: Unable to download webpage: The read operation timed out", ErrorNetwork, "76979871"},
+	{"[youtube] dQw4w9WgXcQ: Unable to extract uploader id; please report this issue on https://github.com/ytdl-org/youtube-dl/issues", ErrorExtractorBroken, "dQw4w9WgXcQ"},
+	{"[youtube] dQw4w9WgXcQ: No video formats found!; please report this issue on  https://github.com/yt-dlp/yt-dlp/issues?q= , filling out the appropriate issue template.", ErrorExtractorBroken, "dQw4w9WgXcQ"},
+	{"Unsupported URL: https://example.com/page", ErrorExtractorBroken, ""},
+	{"unable to write data: [Errno 28] No space left on device", ErrorDiskFull, ""},
+	{"Postprocessing: ffprobe and ffmpeg not found. Please install or provide the path using --ffmpeg-location", ErrorPostprocessing, ""},
+	{"Postprocessing: Conversion failed!", ErrorPostprocessing, ""},
+	{"Something nobody has seen before", ErrorUnknown, ""},
+}
+
+func TestClassifyMessage(t *testing.T) {
+	for _, tt := range classifyTests {
+		class, item := classifyMessage(tt.msg)
+		if class != tt.class || item != tt.item {
+			t.Errorf("classifyMessage(%q) = %s, %q, want %s, %q", tt.msg, class, item, tt.class, tt.item)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	exit := errors.New("exit status 1")
+	tests := []struct {
+		name  string
+		err   error
+		class ErrorClass
+		item  string
+	}{
+		{"none", nil, ErrorNone, ""},
+		{"last message wins", &BackendError{Err: exit, Messages: []string{
+			"[youtube] aaa: Video unavailable",
+			"[youtube] bbb: Unable to download webpage: HTTP Error 429: Too Many Requests",
+		}}, ErrorRateLimited, "bbb"},
+		{"unclassified last message", &BackendError{Err: exit, Messages: []string{
+			"[youtube] aaa: Private video. Sign in if you've been granted access to this video",
+			"Something nobody has seen before",
+		}}, ErrorPrivate, "aaa"},
+		{"no messages", &BackendError{Err: exit}, ErrorUnknown, ""},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("refused")}, ErrorNetwork, ""},
+		{"plain error", errors.New("write /home/mda/a.part: no space left on device"), ErrorDiskFull, ""},
+	}
+	for _, tt := range tests {
+		class, item := Classify(tt.err)
+		if class != tt.class || item != tt.item {
+			t.Errorf("%s: Classify() = %s, %q, want %s, %q", tt.name, class, item, tt.class, tt.item)
+		}
+	}
+}
+
+// TestTransient checks the split the retry loop depends on, only rate
+// limits and network failures are tried again.
+func TestTransient(t *testing.T) {
+	transient := map[ErrorClass]bool{ErrorRateLimited: true, ErrorNetwork: true}
+	for _, tt := range classifyTests {
+		err := &BackendError{Err: errors.New("exit status 1"), Messages: []string{tt.msg}}
+		if got := Transient(err); got != transient[tt.class] {
+			t.Errorf("Transient(%q) = %v, want %v", tt.msg, got, transient[tt.class])
+		}
+	}
+	for _, c := range ErrorClasses {
+		if c.Transient() != transient[c] {
+			t.Errorf("%s.Transient() = %v, want %v", c, c.Transient(), transient[c])
+		}
+	}
+	if Transient(nil) || Transient(context.Canceled) {
+		t.Error("Transient() of no error or a cancelled run = true")
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	if m, ok := errorMessage("ERROR: [youtube] abc: Video unavailable"); !ok || m != "[youtube] abc: Video unavailable" {
+		t.Errorf("errorMessage() = %q, %v", m, ok)
+	}
+	if _, ok := errorMessage("WARNING: [youtube] abc: Falling back to generic n function search"); ok {
+		t.Error("errorMessage() took a warning for an error")
+	}
+}
@@ -0,0 +1,158 @@
+package da
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// httpBackend downloads direct links to media files.
+type httpBackend struct {
+	client *http.Client
+}
+
+// NewHTTPBackend returns a Backend that fetches the DA url as a single file,
+// or each of the items of a job that names them.
+// A nil client uses http.DefaultClient.
+func NewHTTPBackend(client *http.Client) Backend {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpBackend{client}
+}
+
+func (h *httpBackend) Name() string {
+	return "http"
+}
+
+func (h *httpBackend) Version(ctx context.Context) (string, error) {
+	return "net/http", nil
+}
+
+// Download fetches job.Items, or job.URL when there are none. A failed item
+// is reported and the others are still fetched, the first error is returned.
+func (h *httpBackend) Download(ctx context.Context, job *Job) error {
+	urls := job.Items
+	if len(urls) == 0 {
+		urls = []string{job.URL}
+	}
+	var first error
+	for _, u := range urls {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := h.fetch(ctx, job, u)
+		if err == nil {
+			continue
+		}
+		if len(urls) > 1 {
+			id := strings.TrimSuffix(path.Base(u), path.Ext(u))
+			job.Emit(Event{Kind: ItemError, Line: fmt.Sprintf("[generic] %s: %s", id, err)})
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// fetch downloads the single file at rawurl.
+func (h *httpBackend) fetch(ctx context.Context, job *Job, rawurl string) error {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &BackendError{Err: fmt.Errorf("GET %s: %s", rawurl, resp.Status),
+			Messages: []string{fmt.Sprintf("HTTP Error %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode))}}
+	}
+	modified := time.Now()
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		modified = lm
+	}
+	if !job.DateAfter.IsZero() && modified.Before(job.DateAfter) {
+		job.Emit(Event{Kind: ItemOutOfRange, Line: fmt.Sprintf("%s upload date is not in range", rawurl)})
+		return nil
+	}
+	base := path.Base(resp.Request.URL.Path)
+	ext := strings.TrimPrefix(path.Ext(base), ".")
+	id := strings.TrimSuffix(base, path.Ext(base))
+	if job.Archive != "" {
+		archived, err := readArchive(job.Archive)
+		if err != nil {
+			return err
+		}
+		if archived["generic "+id] {
+			job.Logger.Debugf("%s has already been recorded in archive", id)
+			return nil
+		}
+	}
+	fields := map[string]string{
+		"id":          id,
+		"title":       id,
+		"ext":         ext,
+		"upload_date": modified.Format(timeFormat),
+		"extractor":   "generic",
+	}
+	if _, ok := job.Options["--restrict-filenames"]; ok {
+		for k, v := range fields {
+			fields[k] = asciiName(v)
+		}
+	}
+	target := expandTemplate(job.Output, fields)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(target + ".part")
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), target); err != nil {
+		return err
+	}
+	job.Logger.Debugf("Downloaded %s to %s", rawurl, target)
+	if job.Archive != "" {
+		if err := appendArchive(job.Archive, "generic", id); err != nil {
+			return err
+		}
+	}
+	job.Emit(Event{Kind: ItemCompleted, Bytes: n, Line: target, Path: target, Date: modified})
+	return nil
+}
+
+// expandTemplate fills a youtube-dl output template the way youtube-dl does:
+// unknown fields become "NA", separators in a field become underscores and
+// leading dots are dropped, so a field can not leave its directory.
+func expandTemplate(tpl string, fields map[string]string) string {
+	safe := make(map[string]string, len(fields))
+	for k, v := range fields {
+		if v == "" {
+			continue
+		}
+		v = strings.TrimLeft(strings.NewReplacer("/", "_", "\\", "_").Replace(v), ".")
+		if v == "" {
+			v = "_"
+		}
+		safe[k] = v
+	}
+	return substitute(tpl, safe, "NA")
+}
@@ -0,0 +1,73 @@
+package da
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHTTPBackendItems(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone.mp3" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "mda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		items   []string
+		want    []string
+		failed  int
+		wantErr bool
+	}{
+		{"url", nil, []string{"feed"}, 0, false},
+		{"items", []string{srv.URL + "/a.mp3", srv.URL + "/b.mp3"}, []string{"a", "b"}, 0, false},
+		{"failed item", []string{srv.URL + "/gone.mp3", srv.URL + "/c.mp3"}, []string{"c"}, 1, true},
+	}
+	for _, tt := range tests {
+		var completed []string
+		failed := 0
+		job := &Job{
+			URL:    srv.URL + "/feed.mp3",
+			Items:  tt.items,
+			Output: filepath.Join(dir, tt.name, "%(id)s.%(ext)s"),
+			Logger: logrus.NewEntry(logrus.New()),
+			Emit: func(e Event) {
+				switch e.Kind {
+				case ItemCompleted:
+					completed = append(completed, filepath.Base(e.Path))
+				case ItemError:
+					failed++
+				}
+			},
+		}
+		err := NewHTTPBackend(nil).Download(context.Background(), job)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Download() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if len(completed) != len(tt.want) || failed != tt.failed {
+			t.Errorf("%s: completed %v and %d failed, want %v and %d", tt.name, completed, failed, tt.want, tt.failed)
+			continue
+		}
+		for i, id := range tt.want {
+			if completed[i] != id+".mp3" {
+				t.Errorf("%s: completed %v, want %v", tt.name, completed, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package da
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultOutputTemplate is where files go unless the server or the DA set
+// another template.
+const DefaultOutputTemplate = "%(playlist)s/%(upload_date)s/%(id)s__%(title)s.%(ext)s"
+
+var ErrPathEscapesHome = errors.New("Output path escapes the home directory")
+
+// Layout says where the files of a DA are written. Template is a youtube-dl
+// output template inside Subdir, which is inside the home directory. ASCII
+// restricts file names to ASCII without spaces and MaxLength, when above 0,
+// truncates every field of the file name to that many characters.
+type Layout struct {
+	Template  string `json:",omitempty"`
+	Subdir    string `json:",omitempty"`
+	ASCII     bool   `json:",omitempty"`
+	MaxLength int    `json:",omitempty"`
+}
+
+func (l *Layout) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	return json.Marshal(l)
+}
+
+func (l *Layout) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, l)
+	case string:
+		return json.Unmarshal([]byte(src), l)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("Layout: cannot convert %T to Layout", src)
+}
+
+// merge returns l with the fields o sets replaced.
+func (l Layout) merge(o *Layout) Layout {
+	if o == nil {
+		return l
+	}
+	if o.Template != "" {
+		l.Template = o.Template
+	}
+	if o.Subdir != "" {
+		l.Subdir = o.Subdir
+	}
+	if o.ASCII {
+		l.ASCII = true
+	}
+	if o.MaxLength > 0 {
+		l.MaxLength = o.MaxLength
+	}
+	return l
+}
+
+// templateFieldRe matches the %(field)s and %(field).10s fields of an
+// output template.
+var templateFieldRe = regexp.MustCompile(`%\(([a-z_]+)\)(?:\.(\d+))?s`)
+
+// substitute fills the fields of an output template, truncating those with
+// a precision. Fields missing from fields become missing.
+func substitute(tpl string, fields map[string]string, missing string) string {
+	return templateFieldRe.ReplaceAllStringFunc(tpl, func(m string) string {
+		sub := templateFieldRe.FindStringSubmatch(m)
+		v, ok := fields[sub[1]]
+		if !ok || v == "" {
+			v = missing
+		}
+		if n, err := strconv.Atoi(sub[2]); err == nil {
+			if r := []rune(v); len(r) > n {
+				v = string(r[:n])
+			}
+		}
+		return v
+	})
+}
+
+// within joins rel to dir and checks the result stays inside dir.
+func within(dir, rel string) (string, error) {
+	if filepath.IsAbs(rel) {
+		return "", ErrPathEscapesHome
+	}
+	path := filepath.Join(dir, rel)
+	r, err := filepath.Rel(dir, path)
+	if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", ErrPathEscapesHome
+	}
+	return path, nil
+}
+
+// output resolves the output template of l under home. A "-o" option, as
+// older DAs set in Parameters, replaces the template and may be absolute as
+// long as it stays inside home.
+func (l Layout) output(home, o string) (string, error) {
+	dir, err := within(home, l.Subdir)
+	if err != nil {
+		return "", err
+	}
+	tpl := l.Template
+	if tpl == "" {
+		tpl = DefaultOutputTemplate
+	}
+	if o != "" {
+		tpl = o
+		if filepath.IsAbs(o) {
+			rel, err := filepath.Rel(dir, o)
+			if err != nil {
+				return "", ErrPathEscapesHome
+			}
+			tpl = rel
+		}
+	}
+	path, err := within(dir, tpl)
+	if err != nil {
+		return "", err
+	}
+	if l.MaxLength > 0 {
+		d, name := filepath.Split(path)
+		name = templateFieldRe.ReplaceAllStringFunc(name, func(m string) string {
+			field := templateFieldRe.FindStringSubmatch(m)[1]
+			if field == "ext" {
+				return m
+			}
+			return "%(" + field + ")." + strconv.Itoa(l.MaxLength) + "s"
+		})
+		path = d + name
+	}
+	rel, err := filepath.Rel(dir, expandTemplate(path, sampleFields))
+	if err != nil {
+		return "", ErrPathEscapesHome
+	}
+	if _, err := within(dir, rel); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// sampleFields fill an output template to check where its files end up.
+var sampleFields = map[string]string{
+	"id": "id", "title": "title", "ext": "mp4", "playlist": "playlist",
+	"upload_date": "20060102", "uploader": "uploader", "extractor": "generic",
+}
+
+// ValidateLayout checks the files of d end up inside home.
+func ValidateLayout(home string, d *DA, server Layout) error {
+	_, err := server.merge(d.Layout).output(home, d.Parameters["-o"])
+	return err
+}
+
+// asciiName replaces what --restrict-filenames does not allow in a file
+// name field.
+func asciiName(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == ' ':
+			b.WriteByte('_')
+		case r < 0x80 && (r == '-' || r == '_' || r == '.' || r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z'):
+			b.WriteRune(r)
+		case r < 0x80:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
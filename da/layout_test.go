@@ -0,0 +1,101 @@
+package da
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWithin(t *testing.T) {
+	home := filepath.FromSlash("/srv/mda")
+	tests := []struct {
+		rel     string
+		want    string
+		wantErr bool
+	}{
+		{"music", "/srv/mda/music", false},
+		{"a/../b", "/srv/mda/b", false},
+		{"", "/srv/mda", false},
+		{"..", "", true},
+		{"../mda2", "", true},
+		{"a/../../etc", "", true},
+		{"/etc", "", true},
+		{"..foo", "/srv/mda/..foo", false},
+	}
+	for _, tt := range tests {
+		got, err := within(home, filepath.FromSlash(tt.rel))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("within(%q) error = %v, wantErr %v", tt.rel, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != filepath.FromSlash(tt.want) {
+			t.Errorf("within(%q) = %q, want %q", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestPipelineValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Pipeline
+		want error
+	}{
+		{"empty", nil, nil},
+		{"steps", Pipeline{{Type: "loudnorm"}, {Type: "tag"}}, nil},
+		{"unknown", Pipeline{{Type: "shred"}}, ErrUnknownStep},
+		{"move", Pipeline{{Type: "move", Options: map[string]string{"library": "library"}}}, nil},
+		{"move up", Pipeline{{Type: "move", Options: map[string]string{"library": "../library"}}}, ErrPathEscapesHome},
+		{"move absolute", Pipeline{{Type: "move", Options: map[string]string{"library": "/etc"}}}, ErrPathEscapesHome},
+	}
+	for _, tt := range tests {
+		if err := tt.p.Validate(); err != tt.want {
+			t.Errorf("%s: Validate() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestLayoutOutput(t *testing.T) {
+	home := filepath.FromSlash("/srv/mda")
+	tests := []struct {
+		name    string
+		layout  Layout
+		o       string
+		want    string
+		wantErr bool
+	}{
+		{"default", Layout{}, "", "/srv/mda/" + DefaultOutputTemplate, false},
+		{"subdir", Layout{Subdir: "music", Template: "%(id)s.%(ext)s"}, "", "/srv/mda/music/%(id)s.%(ext)s", false},
+		{"max length keeps ext", Layout{Template: "%(playlist)s/%(title)s.%(ext)s", MaxLength: 20}, "", "/srv/mda/%(playlist)s/%(title).20s.%(ext)s", false},
+		{"absolute -o inside", Layout{}, "/srv/mda/a/%(id)s.%(ext)s", "/srv/mda/a/%(id)s.%(ext)s", false},
+		{"absolute -o outside", Layout{}, "/tmp/%(id)s.%(ext)s", "", true},
+		{"template escapes", Layout{Template: "../%(id)s.%(ext)s"}, "", "", true},
+		{"subdir escapes", Layout{Subdir: "../other"}, "", "", true},
+		{"absolute template", Layout{Template: "/etc/%(id)s"}, "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := tt.layout.output(home, filepath.FromSlash(tt.o))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: output() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != filepath.FromSlash(tt.want) {
+			t.Errorf("%s: output() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	tpl := "/srv/mda/%(playlist)s/%(title)s.%(ext)s"
+	tests := []struct {
+		fields map[string]string
+		want   string
+	}{
+		{map[string]string{"playlist": "p", "title": "t", "ext": "mp3"}, "/srv/mda/p/t.mp3"},
+		{map[string]string{"playlist": "..", "title": "../../etc/passwd", "ext": "mp3"}, "/srv/mda/_/_.._etc_passwd.mp3"},
+		{map[string]string{"title": ".hidden", "ext": "mp3"}, "/srv/mda/NA/hidden.mp3"},
+	}
+	for _, tt := range tests {
+		if got := expandTemplate(tpl, tt.fields); got != tt.want {
+			t.Errorf("expandTemplate(%v) = %q, want %q", tt.fields, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package da
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// MediaItem is a file a DA downloaded, described by the info.json
+// youtube-dl wrote next to it.
+type MediaItem struct {
+	ID         string `gorm:"primary_key"`
+	DA         string `gorm:"index"`
+	Session    string
+	Extractor  string
+	VideoID    string `gorm:"index"`
+	Title      string
+	URL        string
+	Uploader   string
+	Thumbnail  string
+	UploadDate *time.Time
+	Duration   float64
+	Path       string
+	StorageURL string
+	// StorageKey is the key of the file in its storage, Path is emptied
+	// once the local copy has been deleted.
+	StorageKey string
+	Size       int64
+	Checksum   string
+	Format     string
+	Ext        string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Steps      []StepResult `gorm:"foreignkey:Item" json:",omitempty"`
+	// Description is the one the uploader wrote, shown in feeds.
+	Description string `sql:"type:text" json:",omitempty"`
+}
+
+func (m *MediaItem) BeforeCreate(scope *gorm.Scope) error {
+	scope.SetColumn("ID", uuid.NewV4().String())
+	return nil
+}
+
+// info is the part of youtube-dl's info.json mda keeps.
+type info struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	WebpageURL  string  `json:"webpage_url"`
+	Uploader    string  `json:"uploader"`
+	Thumbnail   string  `json:"thumbnail"`
+	UploadDate  string  `json:"upload_date"`
+	Duration    float64 `json:"duration"`
+	Extractor   string  `json:"extractor_key"`
+	Format      string  `json:"format"`
+	Ext         string  `json:"ext"`
+	Filename    string  `json:"_filename"`
+	Description string  `json:"description"`
+	Chapters    []struct {
+		StartTime float64 `json:"start_time"`
+		EndTime   float64 `json:"end_time"`
+		Title     string  `json:"title"`
+	} `json:"chapters"`
+	// fields are the top level values of the info.json as strings, for
+	// templates.
+	fields map[string]string
+}
+
+func (i *info) extractor() string {
+	return strings.ToLower(i.Extractor)
+}
+
+// url is what youtube-dl needs to download the item again.
+func (i *info) url() string {
+	if i.WebpageURL != "" {
+		return i.WebpageURL
+	}
+	return i.ID
+}
+
+// sidecarExts are the files youtube-dl leaves next to the media file.
+var sidecarExts = []string{".info.json", ".part", ".ytdl", ".jpg", ".jpeg", ".png", ".webp", ".description", ".vtt", ".srt"}
+
+// readInfo parses the info.json at path.
+func readInfo(path string) (*info, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := &info{}
+	if err := json.Unmarshal(b, i); err != nil {
+		return nil, err
+	}
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	i.fields = make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			i.fields[k] = v
+		case float64:
+			i.fields[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			i.fields[k] = strconv.FormatBool(v)
+		}
+	}
+	return i, nil
+}
+
+// mediaFile finds the file an info.json belongs to. Post processing such as
+// --extract-audio changes the extension youtube-dl recorded, so any file
+// sharing the base name that is not a sidecar is accepted.
+func mediaFile(infoPath, filename string) (string, error) {
+	if filename != "" {
+		if _, err := os.Stat(filename); err == nil {
+			return filename, nil
+		}
+	}
+	base := strings.TrimSuffix(infoPath, ".info.json")
+	if filename != "" {
+		base = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(base))
+	if err != nil {
+		return "", err
+	}
+	prefix := filepath.Base(base) + "."
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), prefix) || isSidecar(e.Name()) {
+			continue
+		}
+		return filepath.Join(filepath.Dir(base), e.Name()), nil
+	}
+	return "", os.ErrNotExist
+}
+
+func isSidecar(name string) bool {
+	for _, ext := range sidecarExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// checksum returns the hex encoded sha256 and the size of the file at path.
+func checksum(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// mediaFromInfo builds the MediaItem for i, read from the info.json at
+// infoPath.
+func mediaFromInfo(da *DA, session, infoPath string, i *info) (*MediaItem, error) {
+	path, err := mediaFile(infoPath, i.Filename)
+	if err != nil {
+		return nil, err
+	}
+	m := &MediaItem{
+		DA:          da.ID,
+		Session:     session,
+		Extractor:   i.extractor(),
+		VideoID:     i.ID,
+		Title:       i.Title,
+		URL:         i.url(),
+		Uploader:    i.Uploader,
+		Thumbnail:   i.Thumbnail,
+		Duration:    i.Duration,
+		Format:      i.Format,
+		Ext:         strings.TrimPrefix(filepath.Ext(path), "."),
+		Description: i.Description,
+	}
+	if t, err := time.Parse(timeFormat, i.UploadDate); err == nil {
+		m.UploadDate = &t
+	}
+	return m, m.stat(path)
+}
+
+// mediaFromFile builds the MediaItem for a file downloaded without an
+// info.json, the file name stands in for the id and title.
+func mediaFromFile(da *DA, session, path string, date time.Time) (*MediaItem, error) {
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	m := &MediaItem{
+		DA:        da.ID,
+		Session:   session,
+		Extractor: "generic",
+		VideoID:   name,
+		Title:     name,
+		URL:       da.URL,
+		Ext:       strings.TrimPrefix(ext, "."),
+		Format:    strings.TrimPrefix(ext, "."),
+	}
+	if !date.IsZero() {
+		m.UploadDate = &date
+	}
+	return m, m.stat(path)
+}
+
+func (m *MediaItem) stat(path string) error {
+	sum, size, err := checksum(path)
+	if err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	m.Path, m.Size, m.Checksum = path, size, sum
+	return nil
+}
+
+// saveMedia stores m, replacing the row of an earlier download of the same
+// item by the same DA.
+func saveMedia(db *gorm.DB, m *MediaItem) error {
+	return db.Where(MediaItem{DA: m.DA, Extractor: m.Extractor, VideoID: m.VideoID}).
+		Assign(*m).FirstOrCreate(m).Error
+}
@@ -0,0 +1,104 @@
+package da
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMediaFromInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	// --extract-audio left an m4a where youtube-dl recorded the mp4.
+	infoPath := write("Title-abc.info.json", `{"id": "abc", "title": "Title", "webpage_url": "https://youtu.be/abc",
+		"uploader": "Someone", "upload_date": "20200102", "duration": 61.5, "extractor_key": "Youtube",
+		"_filename": "`+filepath.Join(dir, "Title-abc.mp4")+`", "view_count": 7}`)
+	write("Title-abc.jpg", "thumbnail")
+	write("Title-abc.m4a", "audio")
+
+	i, err := readInfo(infoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.fields["view_count"] != "7" || i.fields["uploader"] != "Someone" {
+		t.Errorf("readInfo() fields = %v", i.fields)
+	}
+	m, err := mediaFromInfo(&DA{ID: "da-1"}, "session", infoPath, i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Path != filepath.Join(dir, "Title-abc.m4a") || m.Ext != "m4a" || m.Size != 5 {
+		t.Errorf("mediaFromInfo() file = %s, %s, %d", m.Path, m.Ext, m.Size)
+	}
+	if m.DA != "da-1" || m.Extractor != "youtube" || m.VideoID != "abc" || m.URL != "https://youtu.be/abc" {
+		t.Errorf("mediaFromInfo() = %+v", m)
+	}
+	if m.UploadDate == nil || !m.UploadDate.Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("mediaFromInfo() upload date = %v", m.UploadDate)
+	}
+	if m.Checksum != "6ed8919ce20490a5e3ad8630a4fab69475297abd07db73918dd5f36fcfaeb11b" {
+		t.Errorf("mediaFromInfo() checksum = %q", m.Checksum)
+	}
+
+	gone := write("Gone-x.info.json", `{"id": "x"}`)
+	if _, err := mediaFromInfo(&DA{}, "session", gone, &info{ID: "x"}); err == nil {
+		t.Error("mediaFromInfo() of an item without a media file succeeded")
+	}
+}
+
+func TestMediaFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "episode-1.mp3")
+	if err := ioutil.WriteFile(p, []byte("mp3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	m, err := mediaFromFile(&DA{ID: "da-1", URL: "https://example.com/feed"}, "session", p, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.VideoID != "episode-1" || m.Title != "episode-1" || m.Extractor != "generic" || m.Ext != "mp3" {
+		t.Errorf("mediaFromFile() = %+v", m)
+	}
+	if m.URL != "https://example.com/feed" || m.UploadDate == nil || !m.UploadDate.Equal(date) || m.Size != 3 {
+		t.Errorf("mediaFromFile() = %+v", m)
+	}
+	if m, err = mediaFromFile(&DA{}, "session", p, time.Time{}); err != nil || m.UploadDate != nil {
+		t.Errorf("mediaFromFile() without a date = %v, %v", m.UploadDate, err)
+	}
+}
+
+func TestIsSidecar(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"a.info.json", true},
+		{"a.mp4.part", true},
+		{"a.webp", true},
+		{"a.en.vtt", true},
+		{"a.m4a", false},
+		{"a.mp4", false},
+	}
+	for _, tt := range tests {
+		if got := isSidecar(tt.name); got != tt.want {
+			t.Errorf("isSidecar(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package da
+
+import (
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+// Metrics are recorded by the Downloader, every metric is labelled by
+// "provider".
+type Metrics struct {
+	Queued    metrics.Gauge
+	Running   metrics.Gauge
+	Succeeded metrics.Counter
+	Failed    metrics.Counter
+	Bytes     metrics.Counter
+	Items     metrics.Histogram
+	Duration  metrics.Histogram
+}
+
+func discardMetrics() Metrics {
+	return Metrics{
+		Queued:    discard.NewGauge(),
+		Running:   discard.NewGauge(),
+		Succeeded: discard.NewCounter(),
+		Failed:    discard.NewCounter(),
+		Bytes:     discard.NewCounter(),
+		Items:     discard.NewHistogram(),
+		Duration:  discard.NewHistogram(),
+	}
+}
+
+// WithMetrics records download metrics to m.
+func WithMetrics(m Metrics) Option {
+	return func(d *downloader) {
+		d.metrics = m
+	}
+}
@@ -0,0 +1,148 @@
+package da
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	ErrInvalidName  = errors.New("Name is Invalid use lowercase letters, digits and dashes")
+	ErrReservedName = errors.New("Name is reserved")
+	ErrNameTaken    = errors.New("A DA with this name already exists")
+	ErrInvalidID    = errors.New("ID is Invalid use a UUID")
+)
+
+// maxName is the longest Name a DA can have.
+const maxName = 64
+
+// ReservedNames can not name a DA, routes use them where an id or name is
+// accepted too, like all in /feeds/all.xml.
+var ReservedNames = map[string]bool{
+	"all": true, "items": true, "providers": true, "sessions": true, "janitor": true,
+	"opml": true, "export": true, "import": true, "feeds": true, "enable": true,
+	"disable": true, "start": true, "remove": true, "change": true,
+}
+
+var (
+	nameRe    = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	nonSlugRe = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// ValidateName checks that name is a slug that can not be mistaken for an
+// id or a route.
+func ValidateName(name string) error {
+	if len(name) > maxName || !nameRe.MatchString(name) {
+		return ErrInvalidName
+	}
+	if _, err := uuid.FromString(name); err == nil {
+		return ErrInvalidName
+	}
+	if ReservedNames[name] {
+		return ErrReservedName
+	}
+	return nil
+}
+
+// ValidateID checks that id, as given by an import, is a UUID in the
+// canonical form of the ids mda generates. Braced, URN and uppercase forms
+// would parse, but never match the stored id.
+func ValidateID(id string) error {
+	u, err := uuid.FromString(id)
+	if err != nil || u.String() != id {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+// Slugify turns s into a Name, which is empty when s has no letters or
+// digits.
+func Slugify(s string) string {
+	s = strings.Trim(nonSlugRe.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(s) > maxName {
+		s = strings.TrimRight(s[:maxName], "-")
+	}
+	return s
+}
+
+// NameFor is the Name a DA of rawurl gets when none was given, the last
+// segment of its path or else its host.
+func NameFor(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "da"
+	}
+	name := Slugify(path.Base(strings.TrimRight(u.Path, "/")))
+	if list := u.Query().Get("list"); list != "" {
+		name = Slugify(list)
+	}
+	if name == "" {
+		name = Slugify(strings.TrimPrefix(u.Hostname(), "www."))
+	}
+	if ValidateName(name) != nil {
+		name = Slugify("da-" + name)
+	}
+	return name
+}
+
+// NameTaken reports whether a DA other than the one with id except is
+// named name.
+func NameTaken(db *gorm.DB, name, except string) (bool, error) {
+	count := 0
+	err := db.Model(&DA{}).Where("name = ? AND id <> ?", name, except).Count(&count).Error
+	return count > 0, err
+}
+
+// NameConflict reports whether err, from storing the DA with id under
+// name, is the unique index on Name refusing a name another DA took after
+// it was checked.
+func NameConflict(db *gorm.DB, err error, name, id string) bool {
+	if err == nil || name == "" {
+		return false
+	}
+	taken, terr := NameTaken(db, name, id)
+	return terr == nil && taken
+}
+
+// UniqueName is base, numbered from 2 when another DA has it already. The
+// name is only free when it is checked, storing it can still fail with a
+// NameConflict.
+func UniqueName(db *gorm.DB, base, except string) (string, error) {
+	name := base
+	for n := 2; ; n++ {
+		taken, err := NameTaken(db, name, except)
+		if err != nil || !taken {
+			return name, err
+		}
+		suffix := fmt.Sprintf("-%d", n)
+		if len(base)+len(suffix) > maxName {
+			base = strings.TrimRight(base[:maxName-len(suffix)], "-")
+		}
+		name = base + suffix
+	}
+}
+
+// BackfillNames names the DAs stored before they had a Name. Their names
+// are NULL, which the unique index on Name allows any number of.
+func BackfillNames(db *gorm.DB) error {
+	das := []DA{}
+	if err := db.Where("name = '' OR name IS NULL").Find(&das).Error; err != nil {
+		return err
+	}
+	for _, d := range das {
+		name, err := UniqueName(db, NameFor(d.URL), d.ID)
+		if err != nil {
+			return err
+		}
+		if err := db.Model(&d).UpdateColumn("name", name).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package da
+
+import "testing"
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name string
+		want error
+	}{
+		{"lofi-beats", nil},
+		{"a1", nil},
+		{"Lofi", ErrInvalidName},
+		{"-lofi", ErrInvalidName},
+		{"lofi--beats", ErrInvalidName},
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430c8", ErrInvalidName},
+		{"all", ErrReservedName},
+	}
+	for _, tt := range tests {
+		if err := ValidateName(tt.name); err != tt.want {
+			t.Errorf("ValidateName(%q) = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want error
+	}{
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430c8", nil},
+		{"lofi-beats", ErrInvalidID},
+		{"../../etc", ErrInvalidID},
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430cz", ErrInvalidID},
+		{"{6ba7b810-9dad-11d1-80b4-00c04fd430c8}", ErrInvalidID},
+		{"urn:uuid:6ba7b810-9dad-11d1-80b4-00c04fd430c8", ErrInvalidID},
+		{"6BA7B810-9DAD-11D1-80B4-00C04FD430C8", ErrInvalidID},
+		{"6ba7b8109dad11d180b400c04fd430c8", ErrInvalidID},
+	}
+	for _, tt := range tests {
+		if err := ValidateID(tt.id); err != tt.want {
+			t.Errorf("ValidateID(%q) = %v, want %v", tt.id, err, tt.want)
+		}
+	}
+}
+
+func TestNameUnique(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+	first := DA{URL: "https://example.com/show", Name: "show"}
+	if err := db.Create(&first).Error; err != nil {
+		t.Fatal(err)
+	}
+	second := DA{URL: "https://example.com/other", Name: "show"}
+	err := db.Create(&second).Error
+	if err == nil {
+		t.Fatal("Create() of a second DA named show succeeded")
+	}
+	if !NameConflict(db, err, second.Name, second.ID) {
+		t.Errorf("NameConflict(%v) = false, want true", err)
+	}
+	if NameConflict(db, err, first.Name, first.ID) {
+		t.Error("NameConflict() of the DA holding the name = true, want false")
+	}
+	// DAs stored before they had a name are NULL, the index allows many.
+	for _, u := range []string{"https://example.com/a", "https://example.com/b"} {
+		if err := db.Exec("INSERT INTO das (id, url) VALUES (?, ?)", u, u).Error; err != nil {
+			t.Fatalf("inserting an unnamed DA: %v", err)
+		}
+	}
+	if err := BackfillNames(db); err != nil {
+		t.Errorf("BackfillNames() = %v", err)
+	}
+	unnamed := 0
+	if err := db.Model(&DA{}).Where("name IS NULL OR name = ''").Count(&unnamed).Error; err != nil || unnamed != 0 {
+		t.Errorf("BackfillNames() left %d DAs without a name, %v", unnamed, err)
+	}
+}
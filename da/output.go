@@ -0,0 +1,55 @@
+package da
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	infoJSONRe  = regexp.MustCompile(`^\[info\] Writing video (?:metadata|description metadata) as JSON to:\s+(.+)$`)
+	completedRe = regexp.MustCompile(`^\[download\]\s+100(?:\.0)?% of\s+~?([\d.]+)\s*([KMGT]i?B|B|bytes)\b`)
+	units       = map[string]float64{
+		"B": 1, "bytes": 1,
+		"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30, "TiB": 1 << 40,
+		"KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12,
+	}
+)
+
+// parseCompleted reports whether line is youtube-dl announcing a finished
+// file and the size it reported for it.
+func parseCompleted(line string) (size int64, ok bool) {
+	m := completedRe.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, true
+	}
+	return int64(f * units[m[2]]), true
+}
+
+// parseInfoJSON returns the path of the info.json youtube-dl announces in
+// line.
+func parseInfoJSON(line string) (path string, ok bool) {
+	m := infoJSONRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	return strings.TrimSpace(m[1]), true
+}
+
+// scanLines is a bufio.SplitFunc that also breaks on the carriage returns
+// youtube-dl uses to redraw its progress line.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for i, b := range data {
+		if b == '\n' || b == '\r' {
+			return i + 1, data[:i], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,329 @@
+package da
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrUnknownStep = errors.New("Post processing step does not exist")
+
+// StepConfig is one post processing step of a DA. Type names the step, the
+// Options each step understands are listed with it in steps.
+type StepConfig struct {
+	Type    string
+	Options map[string]string `json:",omitempty"`
+}
+
+// Pipeline is the ordered list of steps run on every file a DA downloads.
+type Pipeline []StepConfig
+
+func (p Pipeline) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return json.Marshal(p)
+}
+
+func (p *Pipeline) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, p)
+	case string:
+		return json.Unmarshal([]byte(src), p)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("Pipeline: cannot convert %T to Pipeline", src)
+}
+
+// Validate checks that every step exists and that a move stays inside the
+// home directory.
+func (p Pipeline) Validate() error {
+	for _, c := range p {
+		if _, ok := steps[c.Type]; !ok {
+			return ErrUnknownStep
+		}
+		if c.Type == "move" {
+			if _, err := within(".", c.Options["library"]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Paths is a list of files stored as JSON.
+type Paths []string
+
+func (p Paths) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return json.Marshal(p)
+}
+
+func (p *Paths) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, p)
+	case string:
+		return json.Unmarshal([]byte(src), p)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("Paths: cannot convert %T to Paths", src)
+}
+
+// StepResult records how a post processing step went for a MediaItem.
+// Outputs are the files the step wrote besides the item itself.
+type StepResult struct {
+	ID        string `gorm:"primary_key"`
+	Item      string `gorm:"index"`
+	Session   string
+	Step      string
+	Success   bool
+	Error     string
+	Outputs   Paths `sql:"Type:bytea"`
+	Duration  float64
+	CreatedAt time.Time
+}
+
+func (r *StepResult) BeforeCreate(scope *gorm.Scope) error {
+	scope.SetColumn("ID", uuid.NewV4().String())
+	return nil
+}
+
+// stepItem is the file a pipeline works on. Steps replace Path when they
+// change or move the file and add the files they create to Extra.
+type stepItem struct {
+	Path  string
+	Extra []string
+	Info  *info
+	Home  string
+}
+
+// stepFunc runs a step, returning the files it created.
+type stepFunc func(ctx context.Context, p *postprocessor, item *stepItem, opts map[string]string) ([]string, error)
+
+// steps are the post processing steps a Pipeline can use.
+//
+//	loudnorm     i, tp, lra: EBU R128 targets passed to ffmpeg's loudnorm
+//	transcode    formats: comma separated extensions to also write,
+//	             bitrate: optional audio bitrate such as 192k
+//	trimsilence  threshold: level below which audio is silence, -50dB
+//	chapters     keep: "false" removes the file once split
+//	tag          artist, title, album, track, date: templates of the tags,
+//	             parse_title: "true" or a regexp splitting artist and title,
+//	             cover: "false" keeps the current cover art
+//	move         library: directory inside Home
+var steps = map[string]stepFunc{
+	"loudnorm":    loudnorm,
+	"transcode":   transcode,
+	"trimsilence": trimSilence,
+	"chapters":    splitChapters,
+	"tag":         tag,
+	"move":        moveToLibrary,
+}
+
+// postprocessor runs pipelines with ffmpeg.
+type postprocessor struct {
+	ffmpeg string
+}
+
+// run runs pipeline on item and returns a result per step. A failed step
+// stops the pipeline, the error is that of the failed step.
+func (p *postprocessor) run(ctx context.Context, logger *logrus.Entry, pipeline Pipeline, item *stepItem) ([]*StepResult, error) {
+	results := make([]*StepResult, 0, len(pipeline))
+	for _, c := range pipeline {
+		started := time.Now()
+		r := &StepResult{Step: c.Type, Success: true}
+		results = append(results, r)
+		step, ok := steps[c.Type]
+		var outputs []string
+		var err error
+		if ok {
+			outputs, err = step(ctx, p, item, c.Options)
+		} else {
+			err = ErrUnknownStep
+		}
+		r.Duration = time.Since(started).Seconds()
+		r.Outputs = outputs
+		item.Extra = append(item.Extra, outputs...)
+		if err != nil {
+			r.Success = false
+			r.Error = err.Error()
+			logger.WithError(err).WithFields(logrus.Fields{"step": c.Type, "path": item.Path}).Warn("Post processing failed")
+			return results, fmt.Errorf("%s: %s", c.Type, err)
+		}
+		logger.WithFields(logrus.Fields{"step": c.Type, "path": item.Path}).Debug("Post processing step finished")
+	}
+	return results, nil
+}
+
+// ffmpegRun runs ffmpeg with args, reporting the last line it printed on
+// failure.
+func (p *postprocessor) ffmpegRun(ctx context.Context, args ...string) error {
+	args = append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)
+	out, err := exec.CommandContext(ctx, p.ffmpeg, args...).CombinedOutput()
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		return fmt.Errorf("ffmpeg: %s: %s", err, lines[len(lines)-1])
+	}
+	return nil
+}
+
+// filter rewrites path in place through an ffmpeg audio filter.
+func (p *postprocessor) filter(ctx context.Context, path, af string) error {
+	ext := filepath.Ext(path)
+	tmp := strings.TrimSuffix(path, ext) + ".tmp" + ext
+	if err := p.ffmpegRun(ctx, "-i", path, "-map", "0", "-c:v", "copy", "-af", af, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func option(opts map[string]string, key, def string) string {
+	if v, ok := opts[key]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func loudnorm(ctx context.Context, p *postprocessor, item *stepItem, opts map[string]string) ([]string, error) {
+	af := fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s",
+		option(opts, "i", "-16"), option(opts, "tp", "-1.5"), option(opts, "lra", "11"))
+	return nil, p.filter(ctx, item.Path, af)
+}
+
+func trimSilence(ctx context.Context, p *postprocessor, item *stepItem, opts map[string]string) ([]string, error) {
+	trim := fmt.Sprintf("silenceremove=start_periods=1:start_silence=0.1:start_threshold=%s", option(opts, "threshold", "-50dB"))
+	// silenceremove only trims the start reliably, the end is trimmed by
+	// running it on the reversed audio.
+	return nil, p.filter(ctx, item.Path, strings.Join([]string{trim, "areverse", trim, "areverse"}, ","))
+}
+
+func transcode(ctx context.Context, p *postprocessor, item *stepItem, opts map[string]string) ([]string, error) {
+	base := strings.TrimSuffix(item.Path, filepath.Ext(item.Path))
+	var outputs []string
+	for _, format := range strings.Split(opts["formats"], ",") {
+		format = strings.TrimPrefix(strings.TrimSpace(format), ".")
+		if format == "" || "."+format == filepath.Ext(item.Path) {
+			continue
+		}
+		target := base + "." + format
+		args := []string{"-i", item.Path, "-vn"}
+		if b := opts["bitrate"]; b != "" {
+			args = append(args, "-b:a", b)
+		}
+		if err := p.ffmpegRun(ctx, append(args, target)...); err != nil {
+			return outputs, err
+		}
+		outputs = append(outputs, target)
+	}
+	return outputs, nil
+}
+
+// chapterName replaces the characters chapter titles may not use in a file
+// name.
+var chapterName = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "\x00", "")
+
+func splitChapters(ctx context.Context, p *postprocessor, item *stepItem, opts map[string]string) ([]string, error) {
+	if item.Info == nil || len(item.Info.Chapters) == 0 {
+		return nil, nil
+	}
+	ext := filepath.Ext(item.Path)
+	base := strings.TrimSuffix(item.Path, ext)
+	var outputs []string
+	for n, c := range item.Info.Chapters {
+		target := fmt.Sprintf("%s - %02d - %s%s", base, n+1, chapterName.Replace(c.Title), ext)
+		err := p.ffmpegRun(ctx, "-i", item.Path, "-map", "0", "-c", "copy",
+			"-ss", strconv.FormatFloat(c.StartTime, 'f', 3, 64),
+			"-to", strconv.FormatFloat(c.EndTime, 'f', 3, 64), target)
+		if err != nil {
+			return outputs, err
+		}
+		outputs = append(outputs, target)
+	}
+	if opts["keep"] == "false" {
+		if err := os.Remove(item.Path); err != nil {
+			return outputs, err
+		}
+		item.Path, outputs = outputs[0], outputs[1:]
+	}
+	return outputs, nil
+}
+
+// moveToLibrary moves the item and the files earlier steps created into
+// library, keeping their path relative to Home.
+func moveToLibrary(ctx context.Context, p *postprocessor, item *stepItem, opts map[string]string) ([]string, error) {
+	if opts["library"] == "" {
+		return nil, errors.New("library is not set")
+	}
+	library, err := within(item.Home, opts["library"])
+	if err != nil {
+		return nil, err
+	}
+	target := func(path string) string {
+		rel, err := filepath.Rel(item.Home, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			rel = filepath.Base(path)
+		}
+		return filepath.Join(library, rel)
+	}
+	for i, path := range item.Extra {
+		t := target(path)
+		if err := moveFile(path, t); err != nil {
+			return nil, err
+		}
+		item.Extra[i] = t
+	}
+	t := target(item.Path)
+	if err := moveFile(item.Path, t); err != nil {
+		return nil, err
+	}
+	item.Path = t
+	return nil, nil
+}
+
+// moveFile renames from to to, copying when they are on different devices.
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
@@ -0,0 +1,159 @@
+package da
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// flagDialect rewrites a youtube-dl option for a compatible tool. Returning
+// nil drops the option.
+type flagDialect map[string]func(value string) []string
+
+// ytdlpDialect maps the youtube-dl options mda uses that yt-dlp renamed or
+// removed.
+var ytdlpDialect = flagDialect{
+	"--prefer-ffmpeg": func(string) []string { return nil },
+	"--metadata-from-title": func(v string) []string {
+		return []string{"--parse-metadata", "title:" + v}
+	},
+	"--print-json": func(string) []string {
+		return []string{"--print-json", "--no-simulate"}
+	},
+}
+
+// processBackend runs youtube-dl, or a tool with the same command line and
+// output, as a child process.
+type processBackend struct {
+	name    string
+	binary  string
+	dialect flagDialect
+}
+
+// NewYoutubeDL returns the youtube-dl Backend, binary is the path or name of
+// the executable.
+func NewYoutubeDL(binary string) Backend {
+	if binary == "" {
+		binary = "youtube-dl"
+	}
+	return &processBackend{name: "youtube-dl", binary: binary}
+}
+
+// NewYtDlp returns the yt-dlp Backend, binary is the path or name of the
+// executable.
+func NewYtDlp(binary string) Backend {
+	if binary == "" {
+		binary = "yt-dlp"
+	}
+	return &processBackend{name: "yt-dlp", binary: binary, dialect: ytdlpDialect}
+}
+
+func (p *processBackend) Name() string {
+	return p.name
+}
+
+func (p *processBackend) Version(ctx context.Context) (string, error) {
+	path, err := exec.LookPath(p.binary)
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s", path, strings.TrimSpace(string(out))), nil
+}
+
+// args builds the command line for job. Options are sorted so the same job
+// always produces the same command.
+func (p *processBackend) args(job *Job) []string {
+	args := []string{job.URL}
+	if len(job.Items) > 0 {
+		args = append([]string{}, job.Items...)
+	}
+	keys := make([]string, 0, len(job.Options))
+	for k := range job.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, index := range keys {
+		value := job.Options[index]
+		if translate, ok := p.dialect[index]; ok {
+			args = append(args, translate(value)...)
+			continue
+		}
+		if value != "" {
+			args = append(args, index, value)
+		} else {
+			args = append(args, index)
+		}
+	}
+	if job.Output != "" {
+		args = append(args, "-o", job.Output)
+	}
+	if !job.DateAfter.IsZero() {
+		args = append(args, "--dateafter", job.DateAfter.Format(timeFormat))
+	}
+	if job.Archive != "" {
+		args = append(args, archiveOption, job.Archive)
+	}
+	return args
+}
+
+func (p *processBackend) Download(ctx context.Context, job *Job) error {
+	args := p.args(job)
+	cmd := exec.CommandContext(ctx, p.binary, args...)
+	job.Logger.Debug("Command executing with ", p.binary, " ", args)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	errDone := make(chan struct{})
+	var messages []string
+	go func() {
+		defer close(errDone)
+		es := bufio.NewScanner(stderr)
+		es.Split(scanLines)
+		for es.Scan() {
+			if t := es.Text(); t != "" {
+				job.Logger.Debug(p.name, ": ", t)
+				if m, ok := errorMessage(t); ok {
+					messages = append(messages, m)
+					job.Emit(Event{Kind: ItemError, Line: m})
+				}
+			}
+		}
+	}()
+	fs := bufio.NewScanner(stdout)
+	fs.Split(scanLines)
+	for fs.Scan() {
+		t := fs.Text()
+		if size, ok := parseCompleted(t); ok {
+			job.Emit(Event{Kind: ItemCompleted, Bytes: size, Line: t})
+		}
+		if path, ok := parseInfoJSON(t); ok {
+			job.Emit(Event{Kind: ItemInfo, Path: path, Line: t})
+		}
+		if strings.Contains(t, "not in range") {
+			job.Emit(Event{Kind: ItemOutOfRange, Line: t})
+		}
+	}
+	<-errDone
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &BackendError{Err: err, Messages: messages}
+	}
+	return nil
+}
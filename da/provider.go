@@ -0,0 +1,147 @@
+package da
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnsupportedURL  = errors.New("URL does not belong to a supported provider")
+	ErrLocationInvalid = errors.New("Location does not match the provider of the URL")
+)
+
+// Provider is a site mda knows how to download from. DA.Location holds the
+// provider name.
+type Provider struct {
+	Name string
+	// Hosts matches the url host and any of its subdomains.
+	Hosts []string `json:",omitempty"`
+	// Extensions matches the extension of the url path, used for direct
+	// links to media files.
+	Extensions []string `json:",omitempty"`
+	// Options are merged over the server defaults and under DA.Parameters.
+	Options map[string]string `json:",omitempty"`
+	// Backend is used for the provider when the DA does not pick one.
+	Backend string `json:",omitempty"`
+}
+
+// Matches reports whether u belongs to p.
+func (p Provider) Matches(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, h := range p.Hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(u.Path), "."))
+	for _, e := range p.Extensions {
+		if ext != "" && ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry holds the supported providers. Providers are matched in the order
+// they were registered.
+type Registry struct {
+	mu        sync.RWMutex
+	providers []Provider
+}
+
+func NewRegistry(providers ...Provider) *Registry {
+	r := &Registry{}
+	for _, p := range providers {
+		r.Register(p)
+	}
+	return r
+}
+
+// DefaultProviders returns a registry with the providers mda supports out of
+// the box.
+func DefaultProviders() *Registry {
+	return NewRegistry(
+		Provider{Name: "youtube", Hosts: []string{"youtube.com", "youtu.be", "youtube-nocookie.com"}},
+		Provider{Name: "soundcloud", Hosts: []string{"soundcloud.com"}},
+		Provider{Name: "bandcamp", Hosts: []string{"bandcamp.com"}},
+		Provider{Name: "vimeo", Hosts: []string{"vimeo.com"}},
+		Provider{Name: "mixcloud", Hosts: []string{"mixcloud.com"}},
+		Provider{Name: "http", Backend: "http",
+			Extensions: []string{"mp3", "m4a", "aac", "ogg", "opus", "flac", "wav", "mp4", "webm"}},
+	)
+}
+
+// Register adds p, or replaces the provider with the same name keeping its
+// position.
+func (r *Registry) Register(p Provider) {
+	p.Name = strings.ToLower(p.Name)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, v := range r.providers {
+		if v.Name == p.Name {
+			r.providers[i] = p
+			return
+		}
+	}
+	r.providers = append(r.providers, p)
+}
+
+func (r *Registry) Get(name string) (Provider, bool) {
+	name = strings.ToLower(name)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.providers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Provider{}, false
+}
+
+// List returns the providers sorted by name.
+func (r *Registry) List() []Provider {
+	r.mu.RLock()
+	providers := append([]Provider(nil), r.providers...)
+	r.mu.RUnlock()
+	sort.Slice(providers, func(i, k int) bool { return providers[i].Name < providers[k].Name })
+	return providers
+}
+
+// Match returns the first provider rawurl belongs to.
+func (r *Registry) Match(rawurl string) (Provider, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Provider{}, ErrUnsupportedURL
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.providers {
+		if p.Matches(u) {
+			return p, nil
+		}
+	}
+	return Provider{}, ErrUnsupportedURL
+}
+
+// Resolve infers the location of rawurl, or checks it against location when
+// one is given, and returns the provider name to store. A provider without
+// Hosts, like http, accepts any url when it is asked for explicitly.
+func (r *Registry) Resolve(location, rawurl string) (string, error) {
+	p, err := r.Match(rawurl)
+	if err != nil {
+		if g, ok := r.Get(location); ok && len(g.Hosts) == 0 {
+			if u, uerr := url.Parse(rawurl); uerr == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+				return g.Name, nil
+			}
+		}
+		return "", err
+	}
+	if location != "" && !strings.EqualFold(location, p.Name) {
+		return "", ErrLocationInvalid
+	}
+	return p.Name, nil
+}
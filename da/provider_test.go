@@ -0,0 +1,68 @@
+package da
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	r := DefaultProviders()
+	tests := []struct {
+		name     string
+		location string
+		url      string
+		want     string
+		wantErr  error
+	}{
+		{"inferred", "", "https://www.youtube.com/channel/abc", "youtube", nil},
+		{"subdomain", "", "https://artist.bandcamp.com/album/x", "bandcamp", nil},
+		{"explicit", "YouTube", "https://youtu.be/abc", "youtube", nil},
+		{"wrong location", "vimeo", "https://youtu.be/abc", "", ErrLocationInvalid},
+		{"extension", "", "https://cdn.example.com/show/ep1.MP3", "http", nil},
+		{"http on request", "http", "https://example.com/feed", "http", nil},
+		{"unknown host", "", "https://example.com/feed", "", ErrUnsupportedURL},
+		{"unknown location", "nowhere", "https://example.com/feed", "", ErrUnsupportedURL},
+		{"not http", "http", "ftp://example.com/ep1.mp3", "", ErrUnsupportedURL},
+		{"no host", "http", "https:///ep1", "", ErrUnsupportedURL},
+	}
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.location, tt.url)
+		if err != tt.wantErr || got != tt.want {
+			t.Errorf("%s: Resolve(%q, %q) = %q, %v, want %q, %v", tt.name, tt.location, tt.url, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBackendFor(t *testing.T) {
+	d := NewDownloader("", nil,
+		WithBackend(&FakeBackend{BackendName: "fake"}),
+		WithBackend(&FakeBackend{BackendName: "other"}),
+		WithDefaultBackend("fake"),
+		WithProviderBackends(map[string]string{"Vimeo": "other"}),
+	).(*downloader)
+	tests := []struct {
+		name    string
+		da      DA
+		want    string
+		wantErr error
+	}{
+		{"explicit", DA{Location: "youtube", Backend: "other"}, "other", nil},
+		{"explicit over provider", DA{Location: "vimeo", Backend: "fake"}, "fake", nil},
+		{"configured for provider", DA{Location: "vimeo"}, "other", nil},
+		{"asked for by provider", DA{Location: "http"}, "http", nil},
+		{"default", DA{Location: "youtube"}, "fake", nil},
+		{"default without location", DA{}, "fake", nil},
+		{"unknown", DA{Location: "youtube", Backend: "wget"}, "", ErrUnknownBackend},
+	}
+	for _, tt := range tests {
+		b, err := d.backendFor(&tt.da)
+		if err != tt.wantErr {
+			t.Errorf("%s: backendFor() error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && b.Name() != tt.want {
+			t.Errorf("%s: backendFor() = %q, want %q", tt.name, b.Name(), tt.want)
+		}
+	}
+	unknown := NewDownloader("", nil, WithDefaultBackend("wget")).(*downloader)
+	if _, err := unknown.backendFor(&DA{Location: "youtube"}); err != ErrUnknownBackend {
+		t.Errorf("backendFor() with an unknown default = %v, want %v", err, ErrUnknownBackend)
+	}
+}
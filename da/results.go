@@ -0,0 +1,84 @@
+package da
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ItemStatus is the outcome of a single item of a session.
+type ItemStatus string
+
+const (
+	ItemSucceeded ItemStatus = "succeeded"
+	ItemFailed    ItemStatus = "failed"
+)
+
+// ItemResult is the outcome of one item of a session. URL is what a re-run
+// of the item passes to the backend.
+type ItemResult struct {
+	ID         string `gorm:"primary_key"`
+	Session    string `gorm:"index"`
+	DA         string `gorm:"index"`
+	Extractor  string
+	VideoID    string
+	Title      string
+	URL        string
+	Status     ItemStatus
+	ErrorClass ErrorClass
+	Error      string
+	Bytes      int64
+	Path       string
+	CreatedAt  time.Time
+}
+
+func (r *ItemResult) BeforeCreate(scope *gorm.Scope) error {
+	scope.SetColumn("ID", uuid.NewV4().String())
+	return nil
+}
+
+// itemResults pairs the items index stored with the errors the backend
+// reported. An item with both, like one whose post processing failed, is
+// recorded once as failed.
+func itemResults(da *DA, session string, items []*MediaItem, infos map[string]*info, failures []string) []*ItemResult {
+	results := make([]*ItemResult, 0, len(items)+len(failures))
+	byID := make(map[string]*ItemResult)
+	for _, m := range items {
+		r := &ItemResult{Session: session, DA: da.ID, Extractor: m.Extractor, VideoID: m.VideoID,
+			Title: m.Title, URL: m.URL, Status: ItemSucceeded, Bytes: m.Size, Path: m.Path}
+		results = append(results, r)
+		byID[m.VideoID] = r
+	}
+	for _, msg := range failures {
+		class, id := classifyMessage(msg)
+		r, ok := byID[id]
+		if !ok || id == "" {
+			r = &ItemResult{Session: session, DA: da.ID, VideoID: id, URL: id}
+			if i, ok := infos[id]; ok {
+				r.Extractor, r.Title, r.URL = i.extractor(), i.Title, i.url()
+			}
+			results = append(results, r)
+			if id != "" {
+				byID[id] = r
+			}
+		}
+		r.Status, r.ErrorClass, r.Error = ItemFailed, class, msg
+	}
+	return results
+}
+
+// saveResults stores results and rolls their counts up onto stats.
+func saveResults(db *gorm.DB, stats *Stats, results []*ItemResult) error {
+	for _, r := range results {
+		if r.Status == ItemFailed {
+			stats.Failed++
+		} else {
+			stats.Completed++
+		}
+		if err := db.Create(r).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,466 @@
+package da
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/will7200/mda/tracing"
+)
+
+// DefaultMinFree is the free space a run needs to start unless WithMinFree
+// says otherwise.
+const DefaultMinFree Size = 256 << 20
+
+var (
+	ErrDiskFull = errors.New("No space left on device to start downloading")
+	ErrCleaning = errors.New("DA is being cleaned, Please wait until finished")
+)
+
+// The rules a RemovedItem can be removed by.
+const (
+	RuleKeepLast = "keep_last"
+	RuleMaxAge   = "max_age"
+	RuleMaxSize  = "max_size"
+	RuleQuota    = "quota"
+)
+
+// Size is a number of bytes that reads from JSON and YAML as a number or as
+// a "10GiB" style string.
+type Size int64
+
+var sizeRe = regexp.MustCompile(`^\s*([0-9.]+)\s*([a-zA-Z]*)\s*$`)
+
+// sizeUnits are powers of 1024 whichever way they are written.
+var sizeUnits = map[string]int64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+// ParseSize reads sizes such as "500M", "10GiB" or "1048576".
+func ParseSize(s string) (Size, error) {
+	m := sizeRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("%q is not a size", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a size", s)
+	}
+	unit, ok := sizeUnits[strings.ToLower(m[2])]
+	if !ok {
+		return 0, fmt.Errorf("%q has an unknown unit", s)
+	}
+	return Size(n * float64(unit)), nil
+}
+
+func (s Size) String() string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	v, i := float64(s), 0
+	for ; (v >= 1024 || v <= -1024) && i < len(units)-1; i++ {
+		v /= 1024
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", int64(s))
+	}
+	return fmt.Sprintf("%.1f%s", v, units[i])
+}
+
+func (s *Size) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		var n int64
+		if err := json.Unmarshal(b, &n); err != nil {
+			return err
+		}
+		*s = Size(n)
+		return nil
+	}
+	v, err := ParseSize(str)
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+func (s *Size) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var n int64
+	if err := unmarshal(&n); err == nil {
+		*s = Size(n)
+		return nil
+	}
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	v, err := ParseSize(str)
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// Retention says which downloaded items of a DA are kept. KeepLast keeps
+// the newest items, MaxAge the ones uploaded within the duration and
+// MaxSize the newest ones that fit in that many bytes. Items are ordered by
+// upload date, or by when they were indexed when that is unknown. A rule at
+// 0 is unset, a negative one turns the server rule off for a DA.
+type Retention struct {
+	KeepLast int      `json:",omitempty"`
+	MaxAge   Duration `json:",omitempty"`
+	MaxSize  Size     `json:",omitempty"`
+}
+
+func (r *Retention) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+func (r *Retention) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, r)
+	case string:
+		return json.Unmarshal([]byte(src), r)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("Retention: cannot convert %T to Retention", src)
+}
+
+// merge returns r with the rules o sets replaced.
+func (r Retention) merge(o *Retention) Retention {
+	if o == nil {
+		return r
+	}
+	if o.KeepLast != 0 {
+		r.KeepLast = o.KeepLast
+	}
+	if o.MaxAge != 0 {
+		r.MaxAge = o.MaxAge
+	}
+	if o.MaxSize != 0 {
+		r.MaxSize = o.MaxSize
+	}
+	return r
+}
+
+func (r Retention) active() bool {
+	return r.KeepLast > 0 || r.MaxAge > 0 || r.MaxSize > 0
+}
+
+// RemovedItem is a MediaItem the janitor removed and the rule it broke.
+type RemovedItem struct {
+	ID         string
+	DA         string
+	VideoID    string
+	Title      string
+	Path       string
+	StorageURL string `json:",omitempty"`
+	Size       Size
+	Rule       string
+}
+
+// RetentionReport lists what a janitor run removed, or would have removed
+// on a dry run. DA is empty for the server wide quota.
+type RetentionReport struct {
+	DA      string
+	DryRun  bool
+	Removed []RemovedItem
+	Freed   Size
+	Errors  []string `json:",omitempty"`
+}
+
+// WithRetention sets the rules of DAs that do not override them and quota,
+// the most all DAs together may keep, 0 is unlimited.
+func WithRetention(r Retention, quota Size) Option {
+	return func(d *downloader) {
+		d.retention = r
+		d.quota = quota
+	}
+}
+
+// WithMinFree sets the free space a run needs to start, a negative n never
+// checks.
+func WithMinFree(n Size) Option {
+	return func(d *downloader) {
+		if n != 0 {
+			d.minFree = n
+		}
+	}
+}
+
+// checkFree fails with ErrDiskFull when the volume of Home has less than
+// the minimum free space left. Volumes whose free space can not be read are
+// not checked.
+func (d *downloader) checkFree(logger *logrus.Entry) error {
+	if d.minFree <= 0 {
+		return nil
+	}
+	free, err := freeSpace(d.Home)
+	if err != nil {
+		logger.WithError(err).Debug("Could not read free space")
+		return nil
+	}
+	if free < uint64(d.minFree) {
+		logger.WithFields(logrus.Fields{"free": Size(free).String(), "required": d.minFree.String()}).Warn("Not enough free space")
+		return ErrDiskFull
+	}
+	return nil
+}
+
+// candidate is an indexed item with the files post processing made of it.
+type candidate struct {
+	item    MediaItem
+	outputs []string
+	size    int64
+}
+
+func (c *candidate) date() time.Time {
+	if c.item.UploadDate != nil {
+		return *c.item.UploadDate
+	}
+	return c.item.CreatedAt
+}
+
+// candidates returns the indexed items of the DA with id, or of every DA
+// when id is empty, newest first.
+func candidates(db *gorm.DB, id string) ([]*candidate, error) {
+	items := []MediaItem{}
+	if err := db.Where(MediaItem{DA: id}).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	cs := make([]*candidate, len(items))
+	byID := make(map[string]*candidate, len(items))
+	ids := make([]string, len(items))
+	for i := range items {
+		cs[i] = &candidate{item: items[i], size: items[i].Size}
+		byID[items[i].ID] = cs[i]
+		ids[i] = items[i].ID
+	}
+	if len(ids) > 0 {
+		results := []StepResult{}
+		if err := db.Where("item in (?)", ids).Find(&results).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range results {
+			c := byID[r.Item]
+			for _, path := range r.Outputs {
+				c.outputs = append(c.outputs, path)
+				if fi, err := os.Stat(path); err == nil {
+					c.size += fi.Size()
+				}
+			}
+		}
+	}
+	sort.SliceStable(cs, func(i, k int) bool { return cs[i].date().After(cs[k].date()) })
+	return cs, nil
+}
+
+// removal is a candidate a rule does not keep.
+type removal struct {
+	*candidate
+	rule string
+}
+
+// expire returns the candidates, newest first, that r does not keep. Once
+// MaxSize is reached every older item goes, even those that would fit.
+func (r Retention) expire(cs []*candidate, now time.Time) []removal {
+	var removals []removal
+	var kept int64
+	full := false
+	for i, c := range cs {
+		var rule string
+		switch {
+		case r.KeepLast > 0 && i >= r.KeepLast:
+			rule = RuleKeepLast
+		case r.MaxAge > 0 && c.date().Before(now.Add(-time.Duration(r.MaxAge))):
+			rule = RuleMaxAge
+		case r.MaxSize > 0 && (full || kept+c.size > int64(r.MaxSize)):
+			full = true
+			rule = RuleMaxSize
+		default:
+			kept += c.size
+			continue
+		}
+		removals = append(removals, removal{c, rule})
+	}
+	return removals
+}
+
+// Clean enforces the retention rules of da. A DA that is queued is left
+// alone since its run may still index items, and it can not be queued
+// until Clean is done.
+func (d *downloader) Clean(ctx context.Context, da *DA, dryRun bool) (*RetentionReport, error) {
+	if !reserve(da.ID) {
+		return nil, ErrAlreadyInQueue
+	}
+	defer release(da.ID)
+	db := tracing.DB(ctx, d.db)
+	report := &RetentionReport{DA: da.ID, DryRun: dryRun, Removed: []RemovedItem{}}
+	r := d.retention.merge(da.Retention)
+	if !r.active() {
+		return report, nil
+	}
+	cs, err := candidates(db, da.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rm := range r.expire(cs, time.Now()) {
+		d.remove(ctx, db, report, da, rm)
+	}
+	return report, nil
+}
+
+// Janitor cleans every DA and then removes the oldest items of all DAs
+// until they fit in the quota. Only reports that removed something, or
+// failed to, are returned.
+func (d *downloader) Janitor(ctx context.Context, dryRun bool) ([]*RetentionReport, error) {
+	db := tracing.DB(ctx, d.db)
+	das := []DA{}
+	if err := db.Find(&das).Error; err != nil {
+		return nil, err
+	}
+	reports := []*RetentionReport{}
+	byID := make(map[string]*DA, len(das))
+	removed := make(map[string]bool)
+	for i := range das {
+		da := &das[i]
+		byID[da.ID] = da
+		report, err := d.Clean(ctx, da, dryRun)
+		if err == ErrAlreadyInQueue {
+			continue
+		}
+		if err != nil {
+			return reports, err
+		}
+		for _, item := range report.Removed {
+			removed[item.ID] = true
+		}
+		if len(report.Removed) > 0 || len(report.Errors) > 0 {
+			reports = append(reports, report)
+		}
+	}
+	if d.quota <= 0 {
+		return reports, nil
+	}
+	all, err := candidates(db, "")
+	if err != nil {
+		return reports, err
+	}
+	cs := all[:0]
+	for _, c := range all {
+		if !removed[c.item.ID] {
+			cs = append(cs, c)
+		}
+	}
+	report := &RetentionReport{DryRun: dryRun, Removed: []RemovedItem{}}
+	// Items of queued DAs are left for the next run.
+	for _, rm := range (Retention{MaxSize: d.quota}).expire(cs, time.Now()) {
+		da, ok := byID[rm.item.DA]
+		if !ok || !reserve(da.ID) {
+			continue
+		}
+		rm.rule = RuleQuota
+		d.remove(ctx, db, report, da, rm)
+		release(da.ID)
+	}
+	if len(report.Removed) > 0 || len(report.Errors) > 0 {
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// remove deletes the files of rm, locally and from the storage of da, drops
+// it from the media index and marks its archive entry as removed so it is
+// not downloaded again. A dry run only reports it.
+func (d *downloader) remove(ctx context.Context, db *gorm.DB, report *RetentionReport, da *DA, rm removal) {
+	item := RemovedItem{ID: rm.item.ID, DA: rm.item.DA, VideoID: rm.item.VideoID, Title: rm.item.Title,
+		Path: rm.item.Path, StorageURL: rm.item.StorageURL, Size: Size(rm.size), Rule: rm.rule}
+	logger := logrus.WithFields(logrus.Fields{"da": item.DA, "item": item.VideoID, "rule": item.Rule, "dry_run": report.DryRun})
+	if !report.DryRun {
+		if err := d.removeItem(ctx, db, da, rm.candidate); err != nil {
+			logger.WithError(err).Warn("Could not remove item")
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", item.VideoID, err))
+			return
+		}
+	}
+	logger.WithFields(logrus.Fields{"path": item.Path, "size": item.Size.String()}).Info("Removed item")
+	report.Removed = append(report.Removed, item)
+	report.Freed += item.Size
+}
+
+func (d *downloader) removeItem(ctx context.Context, db *gorm.DB, da *DA, c *candidate) error {
+	paths := c.outputs
+	if c.item.Path != "" {
+		paths = append([]string{c.item.Path}, paths...)
+	}
+	if c.item.StorageURL != "" {
+		s, ok := d.sinks[da.Storage]
+		if !ok {
+			return ErrUnknownStorage
+		}
+		key := c.item.StorageKey
+		if key == "" {
+			key = d.storageKey(c.item.Path)
+		}
+		keys := []string{key}
+		for _, path := range c.outputs {
+			keys = append(keys, d.storageKey(path))
+		}
+		for _, key := range keys {
+			if err := s.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err := db.Model(&ArchiveEntry{}).Where(ArchiveEntry{DA: c.item.DA, Extractor: c.item.Extractor, VideoID: c.item.VideoID}).
+		UpdateColumn("removed_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	if err := db.Where(StepResult{Item: c.item.ID}).Delete(StepResult{}).Error; err != nil {
+		return err
+	}
+	return db.Delete(&c.item).Error
+}
+
+// reserve marks the DA with id as being cleaned unless it is queued or
+// already being cleaned.
+func reserve(id string) bool {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	if _, ok := queue[id]; ok || cleaning[id] {
+		return false
+	}
+	cleaning[id] = true
+	return true
+}
+
+func release(id string) {
+	queueMu.Lock()
+	delete(cleaning, id)
+	queueMu.Unlock()
+}
@@ -0,0 +1,88 @@
+package da
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetentionExpire(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := func(n int) *time.Time {
+		d := now.AddDate(0, 0, -n)
+		return &d
+	}
+	// Newest first, as candidates returns them.
+	cs := []*candidate{
+		{item: MediaItem{ID: "a", UploadDate: day(1)}, size: 100},
+		{item: MediaItem{ID: "b", UploadDate: day(5)}, size: 300},
+		{item: MediaItem{ID: "c", UploadDate: day(10)}, size: 50},
+		{item: MediaItem{ID: "d", CreatedAt: *day(40)}, size: 50},
+	}
+	tests := []struct {
+		name string
+		r    Retention
+		want map[string]string
+	}{
+		{"none", Retention{}, map[string]string{}},
+		{"keep last", Retention{KeepLast: 2}, map[string]string{"c": RuleKeepLast, "d": RuleKeepLast}},
+		{"max age", Retention{MaxAge: Duration(7 * 24 * time.Hour)}, map[string]string{"c": RuleMaxAge, "d": RuleMaxAge}},
+		{"max size", Retention{MaxSize: 400}, map[string]string{"c": RuleMaxSize, "d": RuleMaxSize}},
+		{"max size removes older items that fit", Retention{MaxSize: 150}, map[string]string{"b": RuleMaxSize, "c": RuleMaxSize, "d": RuleMaxSize}},
+		{"keep last before max age", Retention{KeepLast: 3, MaxAge: Duration(7 * 24 * time.Hour)}, map[string]string{"c": RuleMaxAge, "d": RuleKeepLast}},
+	}
+	for _, tt := range tests {
+		got := tt.r.expire(cs, now)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expire() removed %d items, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for _, rm := range got {
+			if rule, ok := tt.want[rm.item.ID]; !ok || rule != rm.rule {
+				t.Errorf("%s: expire() removed %s by %q, want %q", tt.name, rm.item.ID, rm.rule, rule)
+			}
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Size
+		wantErr bool
+	}{
+		{"1048576", 1 << 20, false},
+		{"500M", 500 << 20, false},
+		{"10GiB", 10 << 30, false},
+		{"1.5 kb", 1536, false},
+		{"10 parsecs", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestReserve(t *testing.T) {
+	if !reserve("da-1") {
+		t.Fatal("reserve() of an idle DA = false")
+	}
+	if reserve("da-1") {
+		t.Error("reserve() of a DA being cleaned = true")
+	}
+	if err := (&downloader{}).AddItems(context.Background(), &DA{ID: "da-1"}, nil); err != ErrCleaning {
+		t.Errorf("AddItems() while cleaning = %v, want %v", err, ErrCleaning)
+	}
+	release("da-1")
+	if !reserve("da-1") {
+		t.Error("reserve() after release = false")
+	}
+	release("da-1")
+}
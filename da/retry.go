@@ -0,0 +1,132 @@
+package da
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that reads and writes JSON and YAML as "30s"
+// style strings.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// RetryPolicy says how often and how fast a failed run is tried again. A
+// failed attempt waits Base, doubled for every further attempt and capped at
+// Cap. MaxAttempts counts the first attempt, so 1 or less never retries.
+type RetryPolicy struct {
+	MaxAttempts int
+	Base        Duration
+	Cap         Duration
+}
+
+// Backoff is the wait after the given failed attempt, counting from 1.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	wait := time.Duration(p.Base)
+	for i := 1; i < attempt && (p.Cap <= 0 || wait < time.Duration(p.Cap)); i++ {
+		wait *= 2
+	}
+	if p.Cap > 0 && wait > time.Duration(p.Cap) {
+		wait = time.Duration(p.Cap)
+	}
+	return wait
+}
+
+// Retry reports whether the run should be tried again after attempt failed
+// with err.
+func (p RetryPolicy) Retry(attempt int, err error) bool {
+	return attempt < p.MaxAttempts && Transient(err)
+}
+
+func (p *RetryPolicy) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return json.Marshal(p)
+}
+
+func (p *RetryPolicy) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, p)
+	case string:
+		return json.Unmarshal([]byte(src), p)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("RetryPolicy: cannot convert %T to RetryPolicy", src)
+}
+
+// BackendError is a failed download together with the error messages the
+// tool printed.
+type BackendError struct {
+	Err      error
+	Messages []string
+}
+
+func (e *BackendError) Error() string {
+	if len(e.Messages) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Err, e.Messages[len(e.Messages)-1])
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports whether err is a network failure, a rate limit or a
+// server error, which are worth retrying.
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	class, _ := Classify(err)
+	return class.Transient()
+}
+
+// errorMessage reports whether line is an error youtube-dl printed and
+// returns it without the prefix.
+func errorMessage(line string) (string, bool) {
+	if !strings.HasPrefix(line, "ERROR:") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "ERROR:")), true
+}
@@ -0,0 +1,49 @@
+package da
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{Base: Duration(time.Second), Cap: Duration(10 * time.Second)}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.Backoff(tt.attempt); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+	uncapped := RetryPolicy{Base: Duration(time.Second)}
+	if got := uncapped.Backoff(4); got != 8*time.Second {
+		t.Errorf("uncapped Backoff(4) = %s, want 8s", got)
+	}
+}
+
+func TestRetryDefault(t *testing.T) {
+	transient := &BackendError{Err: errors.New("exit status 1"), Messages: []string{"HTTP Error 503: Service Unavailable"}}
+	if DefaultRetry.Retry(1, transient) {
+		t.Error("DefaultRetry retries, retrying must be opted into")
+	}
+	p := RetryPolicy{MaxAttempts: 3}
+	if !p.Retry(1, transient) {
+		t.Error("a transient failure is not retried")
+	}
+	if p.Retry(1, context.Canceled) {
+		t.Error("a cancelled run is retried")
+	}
+	if p.Retry(3, transient) {
+		t.Error("retried past MaxAttempts")
+	}
+}
@@ -0,0 +1,79 @@
+package da
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrUnknownStorage = errors.New("Storage is not configured on this server")
+
+// Storage is where downloaded files are uploaded to. Keys are slash
+// separated paths relative to the root of the storage.
+type Storage interface {
+	// Put uploads the local file at path under key and returns the URL it
+	// can be found at.
+	Put(ctx context.Context, key, path string) (url string, err error)
+	Delete(ctx context.Context, key string) error
+}
+
+// sink is a configured storage, deleteLocal removes files once uploaded.
+type sink struct {
+	Storage
+	deleteLocal bool
+}
+
+// WithStorage makes s available to DAs under name.
+func WithStorage(name string, s Storage, deleteLocal bool) Option {
+	return func(d *downloader) {
+		d.sinks[name] = sink{s, deleteLocal}
+	}
+}
+
+func (d *downloader) HasStorage(name string) bool {
+	_, ok := d.sinks[name]
+	return name == "" || ok
+}
+
+// storageKey is the key of path in a storage, its path relative to Home.
+func (d *downloader) storageKey(path string) string {
+	rel, err := filepath.Rel(d.Home, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// store uploads m and the files post processing created to the storage of
+// da and records where m went.
+func (d *downloader) store(ctx context.Context, da *DA, m *MediaItem, extra []string) error {
+	if da.Storage == "" {
+		return nil
+	}
+	s, ok := d.sinks[da.Storage]
+	if !ok {
+		return ErrUnknownStorage
+	}
+	for _, path := range extra {
+		if _, err := s.Put(ctx, d.storageKey(path), path); err != nil {
+			return err
+		}
+	}
+	key := d.storageKey(m.Path)
+	url, err := s.Put(ctx, key, m.Path)
+	if err != nil {
+		return err
+	}
+	m.StorageURL, m.StorageKey = url, key
+	if s.deleteLocal {
+		for _, path := range append(extra, m.Path) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		m.Path = ""
+	}
+	return nil
+}
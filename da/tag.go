@@ -0,0 +1,164 @@
+package da
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// tagTemplates are the tags the tag step writes and their default
+// templates, youtube-dl output template fields filled from the info.json.
+var tagTemplates = []struct {
+	tag, template string
+}{
+	{"artist", "%(artist)s"},
+	{"title", "%(title)s"},
+	{"album", "%(playlist)s"},
+	{"track", "%(playlist_index)s"},
+	{"date", "%(upload_year)s"},
+}
+
+// taggable are the formats the tag step writes tags into, and whether ffmpeg
+// can embed cover art in them.
+var taggable = map[string]bool{".m4a": true, ".mp3": true, ".flac": true, ".opus": false}
+
+// artistTitleRe is the default rule of parse_title, splitting
+// "Artist - Title" on a dash surrounded by spaces.
+var artistTitleRe = regexp.MustCompile(`^(?P<artist>.+?)\s+[-–—]\s+(?P<title>.+)$`)
+
+// coverExts are the thumbnail files youtube-dl writes with --write-thumbnail.
+var coverExts = []string{".jpg", ".jpeg", ".png", ".webp"}
+
+// tagFields are the template fields of item. artist falls back to the
+// uploader and parse_title, "true" or a regexp with artist and title groups,
+// takes both from the title.
+func tagFields(item *stepItem, opts map[string]string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for k, v := range item.Info.fields {
+		fields[k] = v
+	}
+	if fields["artist"] == "" {
+		fields["artist"] = fields["uploader"]
+	}
+	if d := fields["upload_date"]; len(d) >= 4 {
+		fields["upload_year"] = d[:4]
+	}
+	if rule := opts["parse_title"]; rule != "" && rule != "false" {
+		re := artistTitleRe
+		if rule != "true" {
+			var err error
+			if re, err = regexp.Compile(rule); err != nil {
+				return nil, fmt.Errorf("parse_title: %s", err)
+			}
+		}
+		if m := re.FindStringSubmatch(fields["title"]); m != nil {
+			for i, name := range re.SubexpNames() {
+				if name == "artist" || name == "title" {
+					fields[name] = strings.TrimSpace(m[i])
+				}
+			}
+		}
+	}
+	return fields, nil
+}
+
+// fillTemplate is expandTemplate leaving unknown fields empty.
+func fillTemplate(tpl string, fields map[string]string) string {
+	return substitute(tpl, fields, "")
+}
+
+// tag writes artist, title, album, track and date into m4a, mp3, opus and
+// flac files, other formats are left alone. The template of a tag can be
+// set in opts under its name. Unless cover is "false" the thumbnail is
+// embedded as cover art.
+func tag(ctx context.Context, p *postprocessor, item *stepItem, opts map[string]string) ([]string, error) {
+	ext := strings.ToLower(filepath.Ext(item.Path))
+	canCover, ok := taggable[ext]
+	if !ok || item.Info == nil {
+		return nil, nil
+	}
+	fields, err := tagFields(item, opts)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-i", item.Path}
+	var cover string
+	if canCover && opts["cover"] != "false" {
+		var downloaded bool
+		if cover, downloaded, err = findCover(ctx, item); err != nil {
+			return nil, err
+		}
+		if downloaded {
+			defer os.Remove(cover)
+		}
+	}
+	switch {
+	case cover != "":
+		// Thumbnails are often webp, which m4a can not hold.
+		args = append(args, "-i", cover, "-map", "0:a", "-map", "1:0", "-c:a", "copy",
+			"-c:v", "mjpeg", "-disposition:v:0", "attached_pic")
+	case canCover:
+		args = append(args, "-map", "0", "-c", "copy")
+	default:
+		args = append(args, "-map", "0:a", "-c", "copy")
+	}
+	if ext == ".mp3" {
+		args = append(args, "-id3v2_version", "3")
+	}
+	for _, t := range tagTemplates {
+		if v := fillTemplate(option(opts, t.tag, t.template), fields); v != "" {
+			args = append(args, "-metadata", t.tag+"="+v)
+		}
+	}
+	tmp := strings.TrimSuffix(item.Path, filepath.Ext(item.Path)) + ".tmp" + filepath.Ext(item.Path)
+	if err := p.ffmpegRun(ctx, append(args, tmp)...); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return nil, os.Rename(tmp, item.Path)
+}
+
+// findCover returns a thumbnail written next to the item, or downloads the
+// one named in its info.json and reports that it is to be removed.
+func findCover(ctx context.Context, item *stepItem) (path string, downloaded bool, err error) {
+	base := strings.TrimSuffix(item.Path, filepath.Ext(item.Path))
+	for _, ext := range coverExts {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext, false, nil
+		}
+	}
+	url := item.Info.fields["thumbnail"]
+	if url == "" {
+		return "", false, nil
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", false, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	f, err := os.Create(base + ".cover" + filepath.Ext(req.URL.Path))
+	if err != nil {
+		return "", false, err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", false, err
+	}
+	return f.Name(), true, nil
+}
@@ -0,0 +1,167 @@
+package da
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTagFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string]string
+		opts    map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"uploader as artist", map[string]string{"uploader": "Someone", "title": "A - B", "upload_date": "20200102"}, nil,
+			map[string]string{"artist": "Someone", "title": "A - B", "upload_year": "2020"}, false},
+		{"artist kept", map[string]string{"artist": "Band", "uploader": "Label"}, nil,
+			map[string]string{"artist": "Band"}, false},
+		{"parse title", map[string]string{"uploader": "Label", "title": "Band – Song (Live)"}, map[string]string{"parse_title": "true"},
+			map[string]string{"artist": "Band", "title": "Song (Live)"}, false},
+		{"title without dash", map[string]string{"uploader": "Label", "title": "Song"}, map[string]string{"parse_title": "true"},
+			map[string]string{"artist": "Label", "title": "Song"}, false},
+		{"parse title off", map[string]string{"uploader": "Label", "title": "Band - Song"}, map[string]string{"parse_title": "false"},
+			map[string]string{"artist": "Label", "title": "Band - Song"}, false},
+		{"own rule", map[string]string{"title": "Song by Band"}, map[string]string{"parse_title": `^(?P<title>.+) by (?P<artist>.+)$`},
+			map[string]string{"artist": "Band", "title": "Song"}, false},
+		{"invalid rule", map[string]string{"title": "Song"}, map[string]string{"parse_title": "("}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := tagFields(&stepItem{Info: &info{fields: tt.fields}}, tt.opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: tagFields() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("%s: tagFields()[%q] = %q, want %q", tt.name, k, got[k], v)
+			}
+		}
+	}
+}
+
+// fakeFFmpeg writes a script standing in for ffmpeg that records its
+// arguments in args, one per line, and creates the output file. It fails
+// when fail is set.
+func fakeFFmpeg(t *testing.T, dir string, fail bool) (ffmpeg, args string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	args = filepath.Join(dir, "args")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + args + "\nfor last; do :; done\n: > \"$last\"\n"
+	if fail {
+		script += "echo 'Invalid data found when processing input' >&2\nexit 1\n"
+	}
+	ffmpeg = filepath.Join(dir, "ffmpeg")
+	if err := ioutil.WriteFile(ffmpeg, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return ffmpeg, args
+}
+
+func TestTag(t *testing.T) {
+	thumbnails := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("jpg"))
+	}))
+	defer thumbnails.Close()
+	tests := []struct {
+		name     string
+		file     string
+		cover    bool
+		fields   map[string]string
+		opts     map[string]string
+		want     []string
+		wantNot  []string
+		untagged bool
+	}{
+		{"mp3 with cover", "a.mp3", true, map[string]string{"uploader": "Someone", "title": "Song", "upload_date": "20200102"}, nil,
+			[]string{"attached_pic", "-id3v2_version", "artist=Someone", "title=Song", "date=2020"}, []string{"album="}, false},
+		{"m4a keeping cover", "a.m4a", true, map[string]string{"title": "Song"}, map[string]string{"cover": "false"},
+			[]string{"-map\n0\n-c\ncopy", "title=Song"}, []string{"attached_pic", "-id3v2_version"}, false},
+		{"opus has no cover", "a.opus", true, map[string]string{"title": "Song"}, nil,
+			[]string{"-map\n0:a\n-c\ncopy"}, []string{"attached_pic"}, false},
+		{"downloaded cover", "a.m4a", false, map[string]string{"title": "Song", "thumbnail": thumbnails.URL + "/maxres.jpg"}, nil,
+			[]string{"a.cover.jpg", "attached_pic"}, nil, false},
+		{"templates", "a.flac", false, map[string]string{"uploader": "Show", "playlist_index": "3"}, map[string]string{"album": "%(uploader)s Podcast"},
+			[]string{"album=Show Podcast", "track=3"}, nil, false},
+		{"video", "a.webm", true, map[string]string{"title": "Song"}, nil, nil, nil, true},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "mda")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ffmpeg, args := fakeFFmpeg(t, dir, false)
+		path := filepath.Join(dir, tt.file)
+		if err := ioutil.WriteFile(path, []byte("media"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if tt.cover {
+			if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("jpg"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		item := &stepItem{Path: path, Info: &info{fields: tt.fields}}
+		if _, err := tag(context.Background(), &postprocessor{ffmpeg: ffmpeg}, item, tt.opts); err != nil {
+			t.Errorf("%s: tag() = %v", tt.name, err)
+		}
+		b, err := ioutil.ReadFile(args)
+		if tt.untagged {
+			if err == nil {
+				t.Errorf("%s: tag() ran ffmpeg with %q", tt.name, b)
+			}
+			os.RemoveAll(dir)
+			continue
+		}
+		got := string(b)
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: ffmpeg arguments %q do not have %q", tt.name, got, w)
+			}
+		}
+		for _, w := range tt.wantNot {
+			if strings.Contains(got, w) {
+				t.Errorf("%s: ffmpeg arguments %q have %q", tt.name, got, w)
+			}
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s: tagged file is gone: %v", tt.name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "a.cover.jpg")); !os.IsNotExist(err) {
+			t.Errorf("%s: downloaded cover was left behind", tt.name)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestTagFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ffmpeg, _ := fakeFFmpeg(t, dir, true)
+	path := filepath.Join(dir, "a.mp3")
+	if err := ioutil.WriteFile(path, []byte("media"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	item := &stepItem{Path: path, Info: &info{fields: map[string]string{"title": "Song"}}}
+	_, err = tag(context.Background(), &postprocessor{ffmpeg: ffmpeg}, item, map[string]string{"cover": "false"})
+	if err == nil || !strings.Contains(err.Error(), "Invalid data found") {
+		t.Errorf("tag() = %v, want the ffmpeg error", err)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "media" {
+		t.Errorf("tag() changed the file to %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.tmp.mp3")); !os.IsNotExist(err) {
+		t.Error("tag() left the temporary file behind")
+	}
+}
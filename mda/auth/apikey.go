@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	ScopeAdmin Scope = "admin"
+
+	keyPrefix = "mda_"
+)
+
+var (
+	ErrUnauthorized = errors.New("API key is missing or invalid")
+	ErrForbidden    = errors.New("API key does not have the required scope")
+	ErrKeyExpired   = errors.New("API key has expired")
+	ErrKeyRevoked   = errors.New("API key has been revoked")
+	ErrKeyDNE       = errors.New("API key does not exist")
+	ErrInvalidScope = errors.New("Scope is invalid, use read, write or admin")
+)
+
+// APIKey is a credential issued by the server. Only the sha256 of the key is
+// stored, the plain key is returned once by Create.
+type APIKey struct {
+	ID         string `gorm:"primary_key"`
+	Hash       string `gorm:"unique_index" json:"-"`
+	Owner      string
+	Scopes     string
+	CreatedAt  *time.Time
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+	RevokedAt  *time.Time
+}
+
+func (APIKey) TableName() string {
+	return "apikeys"
+}
+
+func (k *APIKey) BeforeCreate(scope *gorm.Scope) error {
+	scope.SetColumn("ID", uuid.NewV4().String())
+	return nil
+}
+
+// Allows reports whether the key carries scope s. Admin implies every scope
+// and write implies read.
+func (k *APIKey) Allows(s Scope) bool {
+	for _, v := range strings.Split(k.Scopes, ",") {
+		switch Scope(v) {
+		case s, ScopeAdmin:
+			return true
+		case ScopeWrite:
+			if s == ScopeRead {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseScopes validates a list of scope names.
+func ParseScopes(names []string) ([]Scope, error) {
+	scopes := make([]Scope, 0, len(names))
+	for _, n := range names {
+		s := Scope(strings.ToLower(strings.TrimSpace(n)))
+		switch s {
+		case ScopeRead, ScopeWrite, ScopeAdmin:
+			scopes = append(scopes, s)
+		default:
+			return nil, fmt.Errorf("%s: %q", ErrInvalidScope, n)
+		}
+	}
+	return scopes, nil
+}
+
+// Store issues and checks API keys kept in the apikeys table.
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{db}
+}
+
+// Create issues a new key for owner. A ttl of zero means the key never expires.
+func (s *Store) Create(owner string, scopes []Scope, ttl time.Duration) (key string, k *APIKey, err error) {
+	if owner == "" {
+		return "", nil, fmt.Errorf("Owner is Required")
+	}
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("At least one scope is Required")
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	key = keyPrefix + hex.EncodeToString(b)
+	names := make([]string, len(scopes))
+	for i, v := range scopes {
+		names[i] = string(v)
+	}
+	now := time.Now()
+	k = &APIKey{Hash: hash(key), Owner: owner, Scopes: strings.Join(names, ","), CreatedAt: &now}
+	if ttl > 0 {
+		t := now.Add(ttl)
+		k.ExpiresAt = &t
+	}
+	if err := s.db.Create(k).Error; err != nil {
+		return "", nil, fmt.Errorf("Unable to save API key;Database Error:%s", err.Error())
+	}
+	return key, k, nil
+}
+
+func (s *Store) List() ([]APIKey, error) {
+	keys := []APIKey{}
+	if err := s.db.Order("created_at").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *Store) Revoke(id string) error {
+	k := &APIKey{}
+	if err := s.db.Where(APIKey{ID: id}).First(k).Error; err != nil {
+		return ErrKeyDNE
+	}
+	now := time.Now()
+	return s.db.Model(k).Update(APIKey{RevokedAt: &now}).Error
+}
+
+// Validate looks up key and records its use.
+func (s *Store) Validate(key string) (*APIKey, error) {
+	if key == "" {
+		return nil, ErrUnauthorized
+	}
+	k := &APIKey{}
+	if err := s.db.Where(APIKey{Hash: hash(key)}).First(k).Error; err != nil {
+		return nil, ErrUnauthorized
+	}
+	now := time.Now()
+	if k.RevokedAt != nil {
+		return nil, ErrKeyRevoked
+	}
+	if k.ExpiresAt != nil && now.After(*k.ExpiresAt) {
+		return nil, ErrKeyExpired
+	}
+	s.db.Model(k).UpdateColumn("last_used_at", now)
+	return k, nil
+}
+
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		scopes string
+		scope  Scope
+		want   bool
+	}{
+		{"read", ScopeRead, true},
+		{"read", ScopeWrite, false},
+		{"read", ScopeAdmin, false},
+		{"write", ScopeRead, true},
+		{"write", ScopeWrite, true},
+		{"write", ScopeAdmin, false},
+		{"admin", ScopeRead, true},
+		{"admin", ScopeWrite, true},
+		{"admin", ScopeAdmin, true},
+		{"read,write", ScopeWrite, true},
+		{"", ScopeRead, false},
+		{"Admin", ScopeAdmin, false},
+	}
+	for _, tt := range tests {
+		k := &APIKey{Scopes: tt.scopes}
+		if got := k.Allows(tt.scope); got != tt.want {
+			t.Errorf("APIKey{Scopes: %q}.Allows(%q) = %v, want %v", tt.scopes, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		names   []string
+		want    []Scope
+		wantErr bool
+	}{
+		{[]string{"read"}, []Scope{ScopeRead}, false},
+		{[]string{" Write ", "ADMIN"}, []Scope{ScopeWrite, ScopeAdmin}, false},
+		{[]string{}, []Scope{}, false},
+		{[]string{"read", "root"}, nil, true},
+		{[]string{""}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseScopes(tt.names)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseScopes(%q) error = %v, wantErr %v", tt.names, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseScopes(%q) = %q, want %q", tt.names, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("ParseScopes(%q) = %q, want %q", tt.names, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	a, b := hash("mda_a"), hash("mda_b")
+	if a == b {
+		t.Fatal("different keys hash the same")
+	}
+	if a != hash("mda_a") {
+		t.Fatal("hash is not stable")
+	}
+	if len(a) != 64 || a == "mda_a" {
+		t.Fatalf("hash(%q) = %q, want a hex sha256", "mda_a", a)
+	}
+}
+
+func TestParseAuthorization(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"Bearer mda_x", "mda_x"},
+		{"bearer   mda_x ", "mda_x"},
+		{"mda_x", "mda_x"},
+		{"", ""},
+		{"Bearer", "Bearer"},
+	}
+	for _, tt := range tests {
+		if got := parseAuthorization(tt.header); got != tt.want {
+			t.Errorf("parseAuthorization(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPToContext(t *testing.T) {
+	r := httptest.NewRequest("GET", "/mda/", nil)
+	r.Header.Set("Authorization", "Bearer mda_x")
+	if got, _ := HTTPToContext(context.Background(), r).Value(tokenContextKey).(string); got != "mda_x" {
+		t.Errorf("token = %q, want %q", got, "mda_x")
+	}
+	r = httptest.NewRequest("GET", "/mda/?key=mda_q", nil)
+	if _, ok := HTTPToContext(context.Background(), r).Value(tokenContextKey).(string); ok {
+		t.Error("token taken from the query outside of feeds and files")
+	}
+}
+
+func TestHTTPQueryToContext(t *testing.T) {
+	tests := []struct {
+		header, target, want string
+	}{
+		{"Bearer mda_x", "/feeds/all.xml?key=mda_q", "mda_x"},
+		{"", "/feeds/all.xml?key=mda_q", "mda_q"},
+		{"", "/feeds/all.xml", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		got, _ := HTTPQueryToContext(context.Background(), r).Value(tokenContextKey).(string)
+		if got != tt.want {
+			t.Errorf("%s with %q: token = %q, want %q", tt.target, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestMiddlewareScope(t *testing.T) {
+	next := func(ctx context.Context, request interface{}) (interface{}, error) { return "ok", nil }
+	tests := []struct {
+		scopes string
+		scope  Scope
+		want   error
+	}{
+		{"read", ScopeRead, nil},
+		{"read", ScopeWrite, ErrForbidden},
+		{"write", ScopeWrite, nil},
+		{"write", ScopeAdmin, ErrForbidden},
+		{"admin", ScopeAdmin, nil},
+	}
+	for _, tt := range tests {
+		ctx := NewContext(context.Background(), &APIKey{Scopes: tt.scopes})
+		_, err := Middleware(nil, tt.scope)(next)(ctx, nil)
+		if err != tt.want {
+			t.Errorf("Middleware(%q) with %q error = %v, want %v", tt.scope, tt.scopes, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	"google.golang.org/grpc/metadata"
+)
+
+type contextKey int
+
+const (
+	tokenContextKey contextKey = iota
+	keyContextKey
+)
+
+// HTTPToContext moves the API key from the Authorization header into the
+// context. Both "Bearer <key>" and a bare key are accepted.
+func HTTPToContext(ctx context.Context, r *http.Request) context.Context {
+	return withToken(ctx, parseAuthorization(r.Header.Get("Authorization")))
+}
+
+// HTTPQueryToContext is HTTPToContext falling back to the key query
+// parameter, for the feeds and files podcast apps fetch without headers.
+func HTTPQueryToContext(ctx context.Context, r *http.Request) context.Context {
+	token := parseAuthorization(r.Header.Get("Authorization"))
+	if token == "" {
+		token = r.URL.Query().Get("key")
+	}
+	return withToken(ctx, token)
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+	if token == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tokenContextKey, token)
+}
+
+// HasToken reports whether the request in ctx presented an API key, valid
+// or not.
+func HasToken(ctx context.Context) bool {
+	_, ok := ctx.Value(tokenContextKey).(string)
+	return ok
+}
+
+// GRPCToContext moves the API key from the authorization metadata into the
+// context.
+func GRPCToContext(ctx context.Context, md metadata.MD) context.Context {
+	v, ok := md["authorization"]
+	if !ok || len(v) == 0 {
+		return ctx
+	}
+	return withToken(ctx, parseAuthorization(v[0]))
+}
+
+// NewContext returns a context carrying an already authenticated key.
+func NewContext(ctx context.Context, k *APIKey) context.Context {
+	return context.WithValue(ctx, keyContextKey, k)
+}
+
+// FromContext returns the key that authenticated the request, if any.
+func FromContext(ctx context.Context) (*APIKey, bool) {
+	k, ok := ctx.Value(keyContextKey).(*APIKey)
+	return k, ok
+}
+
+// Middleware rejects requests that do not carry a valid key with scope.
+func Middleware(s *Store, scope Scope) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			k, ok := FromContext(ctx)
+			if !ok {
+				token, _ := ctx.Value(tokenContextKey).(string)
+				var err error
+				k, err = s.Validate(token)
+				if err != nil {
+					return nil, err
+				}
+				ctx = NewContext(ctx, k)
+			}
+			if !k.Allows(scope) {
+				return nil, ErrForbidden
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// Handler protects a plain http.Handler, such as the metrics, like
+// Middleware protects endpoints.
+func Handler(s *Store, scope Scope, next http.Handler) http.Handler {
+	return handler(s, scope, HTTPToContext, next)
+}
+
+// QueryHandler is Handler accepting the key query parameter too, for the
+// file server feeds link to.
+func QueryHandler(s *Store, scope Scope, next http.Handler) http.Handler {
+	return handler(s, scope, HTTPQueryToContext, next)
+}
+
+func handler(s *Store, scope Scope, toContext func(context.Context, *http.Request) context.Context, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := toContext(r.Context(), r).Value(tokenContextKey).(string)
+		k, err := s.Validate(token)
+		switch {
+		case err != nil:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case !k.Allows(scope):
+			http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+		default:
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), k)))
+		}
+	})
+}
+
+func parseAuthorization(h string) string {
+	h = strings.TrimSpace(h)
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return h
+}
@@ -0,0 +1,183 @@
+// Package callback signs and verifies the requests the remote scheduler makes
+// to start a DA. A token is "<unix time>.<nonce>.<hmac>" where the hmac is
+// HMAC-SHA256 over the DA id, the time and the nonce keyed by the server
+// secret. Tokens are only valid for a short window and only once, the nonces
+// of accepted tokens are kept in the database so a restart does not forget
+// them.
+package callback
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Header is the http header carrying the token.
+const Header = "X-Mda-Callback-Token"
+
+var (
+	ErrTokenInvalid  = errors.New("Callback token is invalid")
+	ErrTokenExpired  = errors.New("Callback token has expired")
+	ErrTokenReplayed = errors.New("Callback token has already been used")
+)
+
+type contextKey int
+
+const tokenContextKey contextKey = iota
+
+// Sign returns the hex encoded mac for id at t with nonce.
+func Sign(secret []byte, id string, t time.Time, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(t.Unix(), 10)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewToken returns a fresh token for id.
+func NewToken(secret []byte, id string) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(b)
+	now := time.Now()
+	return strings.Join([]string{strconv.FormatInt(now.Unix(), 10), nonce, Sign(secret, id, now, nonce)}, "."), nil
+}
+
+// Nonces remembers the nonces of accepted tokens until they expire. Use
+// reports false when nonce was used before.
+type Nonces interface {
+	Use(nonce string, expires time.Time) (bool, error)
+}
+
+// MemoryNonces keeps nonces in memory, they are forgotten on restart.
+type MemoryNonces struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func NewMemoryNonces() *MemoryNonces {
+	return &MemoryNonces{seen: make(map[string]time.Time)}
+}
+
+func (m *MemoryNonces) Use(nonce string, expires time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for n, t := range m.seen {
+		if now.After(t) {
+			delete(m.seen, n)
+		}
+	}
+	if _, ok := m.seen[nonce]; ok {
+		return false, nil
+	}
+	m.seen[nonce] = expires
+	return true, nil
+}
+
+// Nonce is an accepted nonce stored by DBNonces.
+type Nonce struct {
+	Nonce     string `gorm:"primary_key"`
+	ExpiresAt time.Time
+}
+
+func (Nonce) TableName() string {
+	return "callback_nonces"
+}
+
+// DBNonces keeps nonces in the callback_nonces table. The primary key makes
+// a second use of a nonce fail even between several servers.
+type DBNonces struct {
+	db *gorm.DB
+}
+
+func NewDBNonces(db *gorm.DB) *DBNonces {
+	return &DBNonces{db}
+}
+
+func (s *DBNonces) Use(nonce string, expires time.Time) (bool, error) {
+	s.db.Where("expires_at < ?", time.Now()).Delete(Nonce{})
+	if err := s.db.Create(&Nonce{Nonce: nonce, ExpiresAt: expires}).Error; err != nil {
+		if !s.db.Where(Nonce{Nonce: nonce}).First(&Nonce{}).RecordNotFound() {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Verifier checks tokens and remembers the nonces it has accepted for as long
+// as they would otherwise be valid.
+type Verifier struct {
+	secret []byte
+	maxAge time.Duration
+	nonces Nonces
+}
+
+// NewVerifier returns a Verifier remembering nonces in nonces, or in memory
+// when nil.
+func NewVerifier(secret []byte, maxAge time.Duration, nonces Nonces) *Verifier {
+	if nonces == nil {
+		nonces = NewMemoryNonces()
+	}
+	return &Verifier{secret: secret, maxAge: maxAge, nonces: nonces}
+}
+
+// Verify checks that token was issued for id within maxAge and has not been
+// used before.
+func (v *Verifier) Verify(id, token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrTokenInvalid
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+	issued := time.Unix(unix, 0)
+	expected := Sign(v.secret, id, issued, parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return ErrTokenInvalid
+	}
+	now := time.Now()
+	if now.Sub(issued) > v.maxAge || issued.Sub(now) > v.maxAge {
+		return ErrTokenExpired
+	}
+	fresh, err := v.nonces.Use(parts[1], issued.Add(v.maxAge))
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrTokenReplayed
+	}
+	return nil
+}
+
+// HTTPToContext moves the callback token header into the context.
+func HTTPToContext(ctx context.Context, r *http.Request) context.Context {
+	token := r.Header.Get(Header)
+	if token == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tokenContextKey, token)
+}
+
+// FromContext returns the callback token of the request, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenContextKey).(string)
+	return token, ok
+}
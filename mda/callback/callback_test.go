@@ -0,0 +1,94 @@
+package callback
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var secret = []byte("s3cret")
+
+func TestSign(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	a := Sign(secret, "id", now, "n")
+	tests := []struct {
+		name   string
+		secret []byte
+		id     string
+		t      time.Time
+		nonce  string
+	}{
+		{"secret", []byte("other"), "id", now, "n"},
+		{"id", secret, "id2", now, "n"},
+		{"time", secret, "id", now.Add(time.Second), "n"},
+		{"nonce", secret, "id", now, "m"},
+		{"boundary", secret, "i", now, "dn"},
+	}
+	if a != Sign(secret, "id", now, "n") {
+		t.Fatal("Sign is not stable")
+	}
+	for _, tt := range tests {
+		if Sign(tt.secret, tt.id, tt.t, tt.nonce) == a {
+			t.Errorf("changing the %s does not change the mac", tt.name)
+		}
+	}
+}
+
+// token builds a token for id issued at t.
+func token(id string, t time.Time, nonce string) string {
+	return strings.Join([]string{strconv.FormatInt(t.Unix(), 10), nonce, Sign(secret, id, t, nonce)}, ".")
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		id    string
+		token string
+		want  error
+	}{
+		{"valid", "id", token("id", now, "a"), nil},
+		{"other id", "id2", token("id", now, "b"), ErrTokenInvalid},
+		{"other secret", "id", strings.Join([]string{strconv.FormatInt(now.Unix(), 10), "c", Sign([]byte("x"), "id", now, "c")}, "."), ErrTokenInvalid},
+		{"tampered time", "id", strconv.FormatInt(now.Unix()+1, 10) + token("id", now, "d")[len(strconv.FormatInt(now.Unix(), 10)):], ErrTokenInvalid},
+		{"malformed", "id", "abc", ErrTokenInvalid},
+		{"bad time", "id", "x.e." + Sign(secret, "id", now, "e"), ErrTokenInvalid},
+		{"expired", "id", token("id", now.Add(-2*time.Minute), "f"), ErrTokenExpired},
+		{"future", "id", token("id", now.Add(2*time.Minute), "g"), ErrTokenExpired},
+	}
+	v := NewVerifier(secret, time.Minute, nil)
+	for _, tt := range tests {
+		if err := v.Verify(tt.id, tt.token); err != tt.want {
+			t.Errorf("%s: Verify() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	nonces := NewMemoryNonces()
+	tok, err := NewToken(secret, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewVerifier(secret, time.Minute, nonces).Verify("id", tok); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	// A new Verifier sharing the nonces stands in for a restarted server.
+	if err := NewVerifier(secret, time.Minute, nonces).Verify("id", tok); err != ErrTokenReplayed {
+		t.Fatalf("second use = %v, want %v", err, ErrTokenReplayed)
+	}
+}
+
+func TestMemoryNoncesExpire(t *testing.T) {
+	m := NewMemoryNonces()
+	if ok, _ := m.Use("a", time.Now().Add(-time.Second)); !ok {
+		t.Fatal("first use refused")
+	}
+	if ok, _ := m.Use("a", time.Now().Add(time.Minute)); !ok {
+		t.Fatal("expired nonce still remembered")
+	}
+	if ok, _ := m.Use("a", time.Now().Add(time.Minute)); ok {
+		t.Fatal("nonce accepted twice")
+	}
+}
@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/will7200/mda/mda/auth"
+)
+
+var (
+	apikeyOwner   string
+	apikeyScopes  []string
+	apikeyExpires time.Duration
+)
+
+var apikeycmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "Manage API keys used by the http and grpc transports",
+}
+
+var apikeyCreatecmd = &cobra.Command{
+	Use:   "create",
+	Short: "Issue a new API key, the key is only shown once",
+	Args:  cobra.NoArgs,
+	RunE:  apikeyCreate,
+}
+
+var apikeyListcmd = &cobra.Command{
+	Use:   "list",
+	Short: "List issued API keys",
+	Args:  cobra.NoArgs,
+	RunE:  apikeyList,
+}
+
+var apikeyRevokecmd = &cobra.Command{
+	Use:   "revoke [id]",
+	Short: "Revoke an API key",
+	Args:  cobra.ExactArgs(1),
+	RunE:  apikeyRevoke,
+}
+
+func init() {
+	apikeyCreatecmd.Flags().StringVar(&apikeyOwner, "owner", "", "owner of the key")
+	apikeyCreatecmd.Flags().StringSliceVar(&apikeyScopes, "scope", []string{"read"}, "scopes granted to the key (read, write, admin)")
+	apikeyCreatecmd.Flags().DurationVar(&apikeyExpires, "expires", 0, "lifetime of the key, 0 never expires")
+	apikeycmd.AddCommand(apikeyCreatecmd, apikeyListcmd, apikeyRevokecmd)
+}
+
+func apikeyCreate(cmd *cobra.Command, args []string) error {
+	scopes, err := auth.ParseScopes(apikeyScopes)
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	key, k, err := auth.NewStore(db).Create(apikeyOwner, scopes, apikeyExpires)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ID:     %s\nOwner:  %s\nScopes: %s\nKey:    %s\n", k.ID, k.Owner, k.Scopes, key)
+	return nil
+}
+
+func apikeyList(cmd *cobra.Command, args []string) error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	keys, err := auth.NewStore(db).List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tOWNER\tSCOPES\tCREATED\tLAST USED\tEXPIRES\tREVOKED")
+	for _, k := range keys {
+		fmt.Fprintln(w, strings.Join([]string{k.ID, k.Owner, k.Scopes,
+			formatTime(k.CreatedAt), formatTime(k.LastUsedAt), formatTime(k.ExpiresAt), formatTime(k.RevokedAt)}, "\t"))
+	}
+	return w.Flush()
+}
+
+func apikeyRevoke(cmd *cobra.Command, args []string) error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := auth.NewStore(db).Revoke(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("API key %s has been revoked\n", args[0])
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
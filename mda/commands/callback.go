@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/will7200/mda/mda/callback"
+)
+
+var callbackID string
+
+var callbackcmd = &cobra.Command{
+	Use:   "callback [url]",
+	Short: "Signed POST to a start url, run by the remote scheduler",
+	Long: `Callback is the command registered with the remote scheduler for every DA.
+It signs the request with scheduler.secret (MDA_SCHEDULER_SECRET) so the
+server can tell it apart from anyone else on the network.`,
+	Args: cobra.ExactArgs(1),
+	RunE: callbackRun,
+}
+
+func init() {
+	callbackcmd.Flags().StringVar(&callbackID, "id", "", "id of the DA being started")
+}
+
+func callbackRun(cmd *cobra.Command, args []string) error {
+	secret := viper.GetString("scheduler.secret")
+	if secret == "" {
+		return fmt.Errorf("scheduler.secret is not set")
+	}
+	if callbackID == "" {
+		return fmt.Errorf("--id is required")
+	}
+	token, err := callback.NewToken([]byte(secret), callbackID)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", args[0], nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(callback.Header, token)
+	c := &http.Client{Timeout: 30 * time.Second}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	fmt.Print(string(body))
+	return nil
+}
@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mssql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/spf13/viper"
+	"github.com/will7200/mda/da"
+	"github.com/will7200/mda/mda/auth"
+	"github.com/will7200/mda/mda/callback"
+)
+
+// openDatabase connects using the database.* settings and migrates every
+// table mda owns.
+func openDatabase() (*gorm.DB, error) {
+	db, err := gorm.Open(viper.GetString("database.dbname"), viper.GetString("database.connection"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect database \ntype %s with connection %s", viper.GetString("database.dbname"), viper.GetString("database.connection"))
+	}
+	if verbose {
+		db.LogMode(true)
+	}
+	if errors := db.AutoMigrate(&da.DA{}, &da.Stats{}, &da.MediaItem{}, &da.ArchiveEntry{}, &da.ItemResult{}, &da.StepResult{}, &auth.APIKey{}, &callback.Nonce{}).GetErrors(); len(errors) != 0 {
+		return nil, fmt.Errorf("Could not auto migrate tables for reasons below %v", errors)
+	}
+	if err := da.BackfillNames(db); err != nil {
+		return nil, fmt.Errorf("Could not name existing DAs %v", err)
+	}
+	// DAs stored before they had an owner are only seen by admin keys
+	// until they are given one.
+	if owner := viper.GetString("auth.default_owner"); owner != "" {
+		if err := db.Model(&da.DA{}).Where("owner = '' OR owner IS NULL").UpdateColumn("owner", owner).Error; err != nil {
+			return nil, fmt.Errorf("Could not assign owner to existing DAs %v", err)
+		}
+	}
+	return db, nil
+}
@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/will7200/mda/mda/service"
+)
+
+var (
+	exportFormat string
+	importFormat string
+	importMode   string
+	importDryRun bool
+	importForce  bool
+)
+
+var exportcmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Write every DA, parameters and watermark included, as JSON or YAML",
+	Long: `Export writes every DA to file, or to stdout without one. The format is
+taken from --format, or else from the extension of file.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: exportRun,
+}
+
+var importcmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Create and update DAs from a document written by export",
+	Long: `Import reads a document written by export. In merge mode existing DAs,
+matched by id, then by name, then by url, are updated and the others
+created. Replace also removes the DAs the document does not list, an empty
+document only with --force. Skip leaves existing DAs alone. Every DA is
+validated as if it was added through the api.`,
+	Args: cobra.ExactArgs(1),
+	RunE: importRun,
+}
+
+func init() {
+	exportcmd.Flags().StringVar(&exportFormat, "format", "", "json or yaml (default from the file extension, else json)")
+	importcmd.Flags().StringVar(&importFormat, "format", "", "json or yaml (default from the file extension, else json)")
+	importcmd.Flags().StringVar(&importMode, "mode", string(service.ImportMerge), "merge, replace or skip")
+	importcmd.Flags().BoolVar(&importDryRun, "dry-run", false, "only show what would change")
+	importcmd.Flags().BoolVar(&importForce, "force", false, "let replace remove every DA when the document lists none")
+}
+
+func exportRun(cmd *cobra.Command, args []string) error {
+	format, out := exportFormat, os.Stdout
+	if len(args) == 1 {
+		if format == "" {
+			format = service.FormatOf(args[0])
+		}
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	svc, _, err := newService(db)
+	if err != nil {
+		return err
+	}
+	doc, err := svc.Export(context.Background())
+	if err != nil {
+		return err
+	}
+	return doc.Encode(out, format)
+}
+
+func importRun(cmd *cobra.Command, args []string) error {
+	mode, err := service.ParseImportMode(importMode)
+	if err != nil {
+		return err
+	}
+	format := importFormat
+	if format == "" {
+		format = service.FormatOf(args[0])
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	doc, err := service.DecodeExport(f, format)
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	svc, _, err := newService(db)
+	if err != nil {
+		return err
+	}
+	report, err := svc.Import(context.Background(), doc, mode, importDryRun, importForce)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tID\tNAME\tURL\tFIELDS/ERROR")
+	for _, c := range report.Changes {
+		detail := strings.Join(c.Fields, ",")
+		if c.Error != "" {
+			detail = c.Error
+		}
+		fmt.Fprintln(w, strings.Join([]string{c.Action, c.ID, c.Name, c.URL, detail}, "\t"))
+	}
+	if report.DryRun {
+		fmt.Fprintln(w, "\ndry run, nothing was changed")
+	}
+	return w.Flush()
+}
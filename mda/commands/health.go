@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/will7200/mda/da"
+	"github.com/will7200/mda/mda/health"
+)
+
+var (
+	startedAt       = time.Now()
+	doctorScheduler bool
+)
+
+var doctorcmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Run the readiness checks and report the results",
+	Args:  cobra.NoArgs,
+	RunE:  doctor,
+}
+
+func init() {
+	doctorcmd.Flags().BoolVar(&doctorScheduler, "scheduler", false, "also check the remote scheduler")
+}
+
+// readinessChecks are shared by /readyz and mda doctor.
+// Only the default backend is required, the others are reported.
+func readinessChecks(db *gorm.DB, d da.Downloader, scheduler bool) []health.Check {
+	checks := []health.Check{
+		health.Database(db),
+		health.WritableDir("home", viper.GetString("interface.home")),
+	}
+	for i, b := range d.Backends() {
+		checks = append(checks, health.Func("backend "+b.Name(), i > 0, b.Version))
+	}
+	if scheduler {
+		checks = append(checks, health.Scheduler(viper.GetString("consul_address"),
+			viper.GetString("acl_token"), viper.GetString("mjs_service_grpc")))
+	}
+	return checks
+}
+
+// debugStatus is served on /debug/status.
+func debugStatus(d da.Downloader) func() interface{} {
+	return func() interface{} {
+		return struct {
+			Uptime     string
+			Downloader da.Status
+			Config     map[string]interface{}
+		}{
+			Uptime:     time.Since(startedAt).String(),
+			Downloader: d.Status(),
+			Config:     health.Redact(viper.AllSettings()),
+		}
+	}
+}
+
+func doctor(cmd *cobra.Command, args []string) error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	results, ok := health.Run(ctx, readinessChecks(db, newDownloader(db), doctorScheduler))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+	for _, r := range results {
+		status, detail := "ok", r.Detail
+		if !r.OK {
+			status, detail = "FAIL", r.Error
+			if r.Optional {
+				status = "warn"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, status, detail)
+	}
+	w.Flush()
+	if !ok {
+		return fmt.Errorf("mda is not ready")
+	}
+	return nil
+}
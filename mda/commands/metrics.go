@@ -0,0 +1,74 @@
+package commands
+
+import (
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/will7200/mda/da"
+)
+
+var (
+	endpointRequests = kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "mda",
+		Subsystem: "endpoint",
+		Name:      "requests_total",
+		Help:      "Number of requests received.",
+	}, []string{"method", "error"})
+	endpointDuration = kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "mda",
+		Subsystem: "endpoint",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+		Buckets:   stdprometheus.DefBuckets,
+	}, []string{"method", "error"})
+)
+
+// downloaderMetrics returns the prometheus backed metrics for da.Downloader.
+func downloaderMetrics() da.Metrics {
+	labels := []string{"provider"}
+	return da.Metrics{
+		Queued: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "mda",
+			Subsystem: "downloader",
+			Name:      "jobs_queued",
+			Help:      "Jobs waiting for a free slot.",
+		}, labels),
+		Running: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "mda",
+			Subsystem: "downloader",
+			Name:      "jobs_running",
+			Help:      "Jobs currently downloading.",
+		}, labels),
+		Succeeded: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "mda",
+			Subsystem: "downloader",
+			Name:      "jobs_succeeded_total",
+			Help:      "Jobs that finished without error.",
+		}, labels),
+		Failed: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "mda",
+			Subsystem: "downloader",
+			Name:      "jobs_failed_total",
+			Help:      "Jobs that finished with an error.",
+		}, labels),
+		Bytes: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "mda",
+			Subsystem: "downloader",
+			Name:      "downloaded_bytes_total",
+			Help:      "Bytes downloaded.",
+		}, labels),
+		Items: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "mda",
+			Subsystem: "downloader",
+			Name:      "run_items",
+			Help:      "Items downloaded per run.",
+			Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100, 200},
+		}, labels),
+		Duration: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "mda",
+			Subsystem: "downloader",
+			Name:      "run_duration_seconds",
+			Help:      "Duration of a run in seconds.",
+			Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+		}, labels),
+	}
+}
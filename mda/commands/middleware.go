@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"github.com/go-kit/kit/endpoint"
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/viper"
+	"github.com/will7200/mda/mda/auth"
+	"github.com/will7200/mda/mda/callback"
+	"github.com/will7200/mda/mda/endpoints"
+	"golang.org/x/time/rate"
+)
+
+var (
+	readMethods  = []string{"Get", "List", "Providers", "Items", "Search", "Archive", "History", "Results", "Feed", "FeedAll", "ExportOPML", "Export"}
+	writeMethods = []string{"Add", "Start", "Remove", "Change", "Enable", "Disable", "ResetArchive", "PruneArchive", "Rewind", "RetryFailed", "Clean", "ImportOPML", "Import"}
+	adminMethods = []string{"Janitor"}
+)
+
+// getEndpointMiddleware builds the per method middleware handed to
+// endpoints.New. The middleware added last runs first.
+func getEndpointMiddleware(db *gorm.DB, proxies endpoints.Proxies) (mw map[string][]endpoint.Middleware) {
+	mw = map[string][]endpoint.Middleware{}
+	var anonymous, keyed endpoint.Middleware
+	if r := viper.GetFloat64("ratelimit.caller"); r > 0 {
+		anonymous, keyed = endpoints.CallerRateLimitMiddleware(rate.Limit(r), burst("ratelimit.caller_burst", r), proxies)
+		addEndpointMiddleware(mw, keyed, allMethods()...)
+	}
+	if viper.GetBool("auth.enabled") {
+		store := auth.NewStore(db)
+		addEndpointMiddleware(mw, auth.Middleware(store, auth.ScopeRead), readMethods...)
+		addEndpointMiddleware(mw, auth.Middleware(store, auth.ScopeWrite), writeMethods...)
+		addEndpointMiddleware(mw, auth.Middleware(store, auth.ScopeAdmin), adminMethods...)
+	}
+	if secret := viper.GetString("scheduler.secret"); secret != "" {
+		v := callback.NewVerifier([]byte(secret), viper.GetDuration("scheduler.token_ttl"), callback.NewDBNonces(db))
+		addEndpointMiddleware(mw, endpoints.CallbackMiddleware(v), "Start")
+	}
+	if anonymous != nil {
+		addEndpointMiddleware(mw, anonymous, allMethods()...)
+	}
+	if r := viper.GetFloat64("ratelimit.global"); r > 0 {
+		limiter := rate.NewLimiter(rate.Limit(r), burst("ratelimit.global_burst", r))
+		addEndpointMiddleware(mw, endpoints.RateLimitMiddleware(limiter), allMethods()...)
+	}
+	for _, m := range allMethods() {
+		addEndpointMiddleware(mw, endpoints.InstrumentingMiddleware(m, endpointRequests, endpointDuration), m)
+		addEndpointMiddleware(mw, endpoints.TracingMiddleware(m), m)
+		addEndpointMiddleware(mw, endpoints.LoggingMiddleware(m), m)
+	}
+	return mw
+}
+
+// burst reads key, falling back to one second worth of requests.
+func burst(key string, r float64) int {
+	if b := viper.GetInt(key); b > 0 {
+		return b
+	}
+	if r < 1 {
+		return 1
+	}
+	return int(r)
+}
+
+func addEndpointMiddleware(mw map[string][]endpoint.Middleware, m endpoint.Middleware, methods ...string) {
+	for _, v := range methods {
+		mw[v] = append(mw[v], m)
+	}
+}
+
+func allMethods() []string {
+	return append(append(append([]string{}, readMethods...), writeMethods...), adminMethods...)
+}
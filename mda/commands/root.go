@@ -50,11 +50,22 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.test.yaml)")
+	RootCmd.PersistentFlags().String("dbname", "sqlite3", "database type")
+	RootCmd.PersistentFlags().String("connection", "./temp_db.db", "database connection string")
+	RootCmd.PersistentFlags().String("homedir", "./mda/", "home directory to download into")
+	viper.BindPFlag("database.dbname", RootCmd.PersistentFlags().Lookup("dbname"))
+	viper.BindPFlag("database.connection", RootCmd.PersistentFlags().Lookup("connection"))
+	viper.BindPFlag("interface.home", RootCmd.PersistentFlags().Lookup("homedir"))
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	RootCmd.AddCommand(servercmd)
+	RootCmd.AddCommand(apikeycmd)
+	RootCmd.AddCommand(callbackcmd)
+	RootCmd.AddCommand(doctorcmd)
+	RootCmd.AddCommand(exportcmd)
+	RootCmd.AddCommand(importcmd)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -77,6 +88,7 @@ func initConfig() {
 	}
 
 	viper.AutomaticEnv() // read in environment variables that match
+	viper.BindEnv("scheduler.secret", "MDA_SCHEDULER_SECRET")
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
@@ -4,21 +4,30 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/mssql"
-	_ "github.com/jinzhu/gorm/dialects/postgres"
-	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/will7200/mda/da"
+	"github.com/will7200/mda/mda/auth"
 	"github.com/will7200/mda/mda/endpoints"
+	"github.com/will7200/mda/mda/feed"
+	"github.com/will7200/mda/mda/health"
 	mdahttp "github.com/will7200/mda/mda/http"
 	"github.com/will7200/mda/mda/service"
+	"github.com/will7200/mda/mda/storage"
+	"github.com/will7200/mda/tracing"
 )
 
 var (
@@ -43,22 +52,30 @@ var servercmd = &cobra.Command{
 func init() {
 	servercmd.Flags().IntVarP(&port, "port", "p", 4004, "port on which to listen to")
 	servercmd.Flags().BoolVar(&verbose, "verbose", false, "output log verbose")
-	servercmd.Flags().String("dbname", "sqlite3", "database type")
-	servercmd.Flags().String("connection", "./temp_db.db", "database connection string")
-	servercmd.Flags().String("homedir", "./mda/", "home directory to download into")
 	servercmd.Flags().BoolVar(&showHTTPDir, "httpdir", false, "Output the http directory")
+	servercmd.Flags().String("advertise-address", "", "host[:port] the remote scheduler calls back on")
+	servercmd.Flags().String("public-url", "", "base url the remote scheduler calls back on, overrides advertise-address")
+	servercmd.Flags().String("trace-exporter", "none", "tracing exporter: none, stdout or file")
+	servercmd.Flags().String("trace-file", "./mda-traces.json", "file the file tracing exporter writes to")
+	servercmd.Flags().Bool("auth", false, "require an API key on every request")
 	//servercmd.Flags().Int("workers", 4, "amount of workers in pool")
 	viper.BindPFlag("verbose", servercmd.Flags().Lookup("verbose"))
 	viper.BindPFlag("interface.port", servercmd.Flags().Lookup("port"))
-	viper.BindPFlag("database.dbname", servercmd.Flags().Lookup("dbname"))
-	viper.BindPFlag("database.connection", servercmd.Flags().Lookup("connection"))
 	viper.BindPFlag("interface.workers", servercmd.Flags().Lookup("workers"))
-	viper.BindPFlag("interface.home", servercmd.Flags().Lookup("homedir"))
+	viper.BindPFlag("interface.advertise_address", servercmd.Flags().Lookup("advertise-address"))
+	viper.BindPFlag("interface.public_url", servercmd.Flags().Lookup("public-url"))
+	viper.BindPFlag("tracing.exporter", servercmd.Flags().Lookup("trace-exporter"))
+	viper.BindPFlag("tracing.file", servercmd.Flags().Lookup("trace-file"))
+	viper.BindPFlag("auth.enabled", servercmd.Flags().Lookup("auth"))
 	viper.SetEnvPrefix("MDA") // will be uppercased automatically
 	viper.BindEnv("verbose")
 	viper.BindEnv("mjs_service_grpc")
 	viper.BindEnv("acl_token")
 	viper.BindEnv("consul_address")
+	viper.SetDefault("downloader.backend", da.DefaultBackend)
+	viper.SetDefault("scheduler.command", "mda")
+	viper.SetDefault("scheduler.token_ttl", 5*time.Minute)
+	viper.SetDefault("downloader.retention.interval", time.Hour)
 }
 func server(cmd *cobra.Command, args []string) error {
 	verbose = viper.GetBool("verbose") || verbose
@@ -71,34 +88,273 @@ func server(cmd *cobra.Command, args []string) error {
 	} else {
 		parsedPort = ":4004"
 	}
-	db, err = gorm.Open(viper.GetString("database.dbname"), viper.GetString("database.connection"))
-	if verbose {
-		db.LogMode(true)
+	shutdown, err := tracing.Setup(viper.GetString("tracing.exporter"), viper.GetString("tracing.file"))
+	if err != nil {
+		return err
 	}
+	defer shutdown(context.Background())
+	db, err = openDatabase()
 	if err != nil {
-		panic(fmt.Sprintf("failed to connect database \ntype %s with connection %s", viper.GetString("database.dbname"), viper.GetString("database.connection")))
+		return err
+	}
+	tracing.RegisterCallbacks(db)
+	// Indexed items store absolute paths, so home is made absolute once for
+	// everything comparing against them.
+	home, err := filepath.Abs(viper.GetString("interface.home"))
+	if err != nil {
+		return err
+	}
+	viper.Set("interface.home", home)
+	if err := os.MkdirAll(home, 0755); err != nil {
+		return err
 	}
-	if errors := db.AutoMigrate(&da.DA{}, &da.Stats{}).GetErrors(); len(errors) != 0 {
-		fmt.Printf("Cound not auto migrate tables for reasons below %v", errors)
-		fmt.Println()
-		panic("Could not make/migrate tables")
+	// Interrupts cancel the downloads and shut the server down gracefully.
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		stop()
+	}()
+	svc, d, err := newService(db, da.WithMetrics(downloaderMetrics()), da.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if err := reconcile(svc, "startup"); err != nil {
+		return err
+	}
+	watchSubscriptions(svc)
+	proxies, err := endpoints.ParseProxies(viper.GetStringSlice("interface.trusted_proxies"))
+	if err != nil {
+		return err
 	}
-	d := da.NewDownloader(viper.GetString("interface.home"), db)
-	svc := service.New(db, d)
-	ep := endpoints.New(svc)
+	ep := endpoints.New(svc, getEndpointMiddleware(db, proxies))
 	r := mdahttp.NewHTTPHandler(ep)
+	var metrics, status http.Handler = promhttp.Handler(), health.StatusHandler(debugStatus(d))
+	var files http.Handler = feed.FileServer(viper.GetString("interface.home"))
+	if viper.GetBool("auth.enabled") {
+		store := auth.NewStore(db)
+		metrics = auth.Handler(store, auth.ScopeAdmin, metrics)
+		status = auth.Handler(store, auth.ScopeAdmin, status)
+		files = auth.QueryHandler(store, auth.ScopeRead, ownedFiles(db, viper.GetString("interface.home"), files))
+	}
+	r.Handle("/metrics", metrics).Methods("GET")
+	r.Handle("/healthz", health.LiveHandler()).Methods("GET")
+	r.Handle("/readyz", health.ReadyHandler(readinessChecks(db, d, viper.GetBool("health.check_scheduler")))).Methods("GET")
+	r.Handle("/debug/status", status).Methods("GET")
+	r.PathPrefix("/files/").Handler(http.StripPrefix("/files", files)).Methods("GET", "HEAD")
 	if verbose || showHTTPDir {
 		showHTTPPaths(r)
 	}
 	log.Infof("Starting Server on port %d", viper.GetInt("interface.port"))
-	go AddtoSchedular(db, &svc)
+	if viper.GetString("scheduler.secret") == "" {
+		log.Warn("scheduler.secret is not set, DAs are not added to the remote scheduler and only start on request")
+	} else {
+		go AddtoSchedular(db, &svc)
+	}
+	go runJanitor(d, viper.GetDuration("downloader.retention.interval"))
+	// Files may take longer to send than the api is given, only the api
+	// runs under a write timeout.
+	api := http.TimeoutHandler(r, 7*time.Second, "")
 	server := &http.Server{
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 7 * time.Second,
-		Addr:         parsedPort,
-		Handler:      r,
+		ReadTimeout: 5 * time.Second,
+		Addr:        parsedPort,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if strings.HasPrefix(req.URL.Path, "/files/") {
+				r.ServeHTTP(w, req)
+				return
+			}
+			api.ServeHTTP(w, req)
+		}),
+	}
+	go func() {
+		<-ctx.Done()
+		log.Info("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// newService builds the service, and the Downloader behind it, from the
+// settings the server runs with.
+func newService(db *gorm.DB, opts ...da.Option) (service.MdaService, da.Downloader, error) {
+	providers, err := loadProviders()
+	if err != nil {
+		return nil, nil, err
+	}
+	sinks, err := loadStorage()
+	if err != nil {
+		return nil, nil, err
+	}
+	d := newDownloader(db, append(append(sinks, opts...), da.WithProviders(providers))...)
+	return service.New(db, d, providers, outputLayout()), d, nil
+}
+
+// newDownloader builds the Downloader from the downloader.* settings.
+func newDownloader(db *gorm.DB, opts ...da.Option) da.Downloader {
+	opts = append([]da.Option{
+		da.WithConcurrency(concurrencyLimits(), viper.GetInt("downloader.default_concurrency")),
+		da.WithBackend(da.NewYoutubeDL(viper.GetString("downloader.backends.youtube-dl.binary"))),
+		da.WithBackend(da.NewYtDlp(viper.GetString("downloader.backends.yt-dlp.binary"))),
+		da.WithDefaultBackend(viper.GetString("downloader.backend")),
+		da.WithProviderBackends(viper.GetStringMapString("downloader.provider_backends")),
+		da.WithStopAfter(viper.GetInt("downloader.stop_after")),
+		da.WithRetry(retryPolicy()),
+		da.WithFFmpeg(viper.GetString("downloader.ffmpeg")),
+		da.WithLayout(outputLayout()),
+		da.WithRetention(retentionRules(), sizeSetting("downloader.retention.quota")),
+		da.WithMinFree(sizeSetting("downloader.min_free")),
+	}, opts...)
+	return da.NewDownloader(viper.GetString("interface.home"), db, opts...)
+}
+
+// providerConfig is the shape of an entry under providers in the config
+// file. Entries add providers or override the built in ones.
+type providerConfig struct {
+	Hosts      []string
+	Extensions []string
+	Options    map[string]string
+	Backend    string
+}
+
+func loadProviders() (*da.Registry, error) {
+	r := da.DefaultProviders()
+	for name := range viper.GetStringMap("providers") {
+		var c providerConfig
+		if err := viper.UnmarshalKey("providers."+name, &c); err != nil {
+			return nil, fmt.Errorf("providers.%s: %s", name, err)
+		}
+		p, ok := r.Get(name)
+		if !ok {
+			p = da.Provider{Name: name}
+		}
+		if len(c.Hosts) > 0 {
+			p.Hosts = c.Hosts
+		}
+		if len(c.Extensions) > 0 {
+			p.Extensions = c.Extensions
+		}
+		if c.Options != nil {
+			p.Options = c.Options
+		}
+		if c.Backend != "" {
+			p.Backend = c.Backend
+		}
+		r.Register(p)
 	}
-	return server.ListenAndServe()
+	return r, nil
+}
+
+// loadStorage opens the storages of the storage section, keyed by the name
+// DAs refer to them by.
+func loadStorage() ([]da.Option, error) {
+	var opts []da.Option
+	for name := range viper.GetStringMap("storage") {
+		var c storage.Config
+		if err := viper.UnmarshalKey("storage."+name, &c); err != nil {
+			return nil, fmt.Errorf("storage.%s: %s", name, err)
+		}
+		s, err := storage.New(c)
+		if err != nil {
+			return nil, fmt.Errorf("storage.%s: %s", name, err)
+		}
+		opts = append(opts, da.WithStorage(name, s, c.DeleteLocal))
+	}
+	return opts, nil
+}
+
+// outputLayout reads downloader.layout, the layout of DAs that do not set
+// their own.
+func outputLayout() da.Layout {
+	return da.Layout{
+		Template:  viper.GetString("downloader.layout.template"),
+		Subdir:    viper.GetString("downloader.layout.subdir"),
+		ASCII:     viper.GetBool("downloader.layout.ascii"),
+		MaxLength: viper.GetInt("downloader.layout.max_length"),
+	}
+}
+
+// retryPolicy reads downloader.retry, settings left out keep the value of
+// da.DefaultRetry.
+func retryPolicy() da.RetryPolicy {
+	p := da.DefaultRetry
+	if viper.IsSet("downloader.retry.max_attempts") {
+		p.MaxAttempts = viper.GetInt("downloader.retry.max_attempts")
+	}
+	if viper.IsSet("downloader.retry.base") {
+		p.Base = da.Duration(viper.GetDuration("downloader.retry.base"))
+	}
+	if viper.IsSet("downloader.retry.cap") {
+		p.Cap = da.Duration(viper.GetDuration("downloader.retry.cap"))
+	}
+	return p
+}
+
+// retentionRules reads downloader.retention, the rules of DAs that do not
+// set their own.
+func retentionRules() da.Retention {
+	return da.Retention{
+		KeepLast: viper.GetInt("downloader.retention.keep_last"),
+		MaxAge:   da.Duration(viper.GetDuration("downloader.retention.max_age")),
+		MaxSize:  sizeSetting("downloader.retention.max_size"),
+	}
+}
+
+// sizeSetting reads a size such as "10GiB" from key, 0 when unset.
+func sizeSetting(key string) da.Size {
+	v := viper.GetString(key)
+	if v == "" {
+		return 0
+	}
+	n, err := da.ParseSize(v)
+	if err != nil {
+		log.Warnf("%s: %s", key, err)
+		return 0
+	}
+	return n
+}
+
+// runJanitor enforces the retention rules every interval, 0 never does.
+func runJanitor(d da.Downloader, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		reports, err := d.Janitor(context.Background(), false)
+		if err != nil {
+			log.WithError(err).Error("Janitor failed")
+			continue
+		}
+		for _, r := range reports {
+			log.WithFields(log.Fields{
+				"da":      r.DA,
+				"removed": len(r.Removed),
+				"freed":   r.Freed.String(),
+				"errors":  len(r.Errors),
+			}).Info("Janitor enforced retention")
+		}
+	}
+}
+
+// concurrencyLimits reads downloader.concurrency, a map of provider to the
+// number of jobs it may run at once.
+func concurrencyLimits() map[string]int {
+	limits := make(map[string]int)
+	for provider, v := range viper.GetStringMapString("downloader.concurrency") {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("downloader.concurrency.%s: %s is not a number", provider, v)
+			continue
+		}
+		limits[provider] = n
+	}
+	return limits
 }
 
 func AddtoSchedular(db *gorm.DB, s *service.MdaService) {
@@ -133,3 +389,24 @@ func showHTTPPaths(r *mux.Router) {
 		return nil
 	})
 }
+
+// ownedFiles only serves keys without admin scope the files indexed for
+// their own DAs.
+func ownedFiles(db *gorm.DB, home string, next http.Handler) http.Handler {
+	if abs, err := filepath.Abs(home); err == nil {
+		home = abs
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if k, ok := auth.FromContext(r.Context()); ok && !k.Allows(auth.ScopeAdmin) {
+			file := filepath.Join(home, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+			count := 0
+			err := db.Model(&da.MediaItem{}).Where("path = ? AND da IN (SELECT id FROM das WHERE owner = ?)", file, k.Owner).
+				Count(&count).Error
+			if err != nil || count == 0 {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/will7200/mda/da"
+	"github.com/will7200/mda/mda/auth"
+)
+
+func TestOwnedFiles(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.DB().SetMaxOpenConns(1)
+	da.CreateDatabaseTables(db)
+	// The default home, ./mda/, is relative while items store absolute paths.
+	home, err := ioutil.TempDir(".", "home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	abs, err := filepath.Abs(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, owner := range []string{"ann", "bob"} {
+		d := da.DA{URL: "https://example.com/" + owner, Owner: owner}
+		if err := db.Create(&d).Error; err != nil {
+			t.Fatal(err)
+		}
+		m := da.MediaItem{DA: d.ID, Path: filepath.Join(abs, owner, "ep 1.mp3")}
+		if err := db.Create(&m).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	served := func(w http.ResponseWriter, r *http.Request) {}
+	h := ownedFiles(db, home, http.HandlerFunc(served))
+	tests := []struct {
+		name string
+		key  *auth.APIKey
+		path string
+		want int
+	}{
+		{"own file", &auth.APIKey{Owner: "ann", Scopes: "read"}, "/ann/ep 1.mp3", http.StatusOK},
+		{"other file", &auth.APIKey{Owner: "ann", Scopes: "read"}, "/bob/ep 1.mp3", http.StatusNotFound},
+		{"not indexed", &auth.APIKey{Owner: "ann", Scopes: "read"}, "/ann/ep 2.mp3", http.StatusNotFound},
+		{"dot dot", &auth.APIKey{Owner: "ann", Scopes: "read"}, "/bob/../ann/ep 1.mp3", http.StatusOK},
+		{"admin", &auth.APIKey{Owner: "root", Scopes: "admin"}, "/bob/ep 1.mp3", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = tt.path
+		r = r.WithContext(auth.NewContext(context.Background(), tt.key))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: GET %s = %d, want %d", tt.name, tt.path, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/will7200/mda/mda/service"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// reconcileMu keeps a reload from running while another one is.
+var reconcileMu sync.Mutex
+
+// reloadDelay lets a burst of file events, as editors write in steps, end in
+// a single reload.
+const reloadDelay = 500 * time.Millisecond
+
+// subscriptionConfig rereads the config file into a viper of its own, the
+// one the server runs with is read by requests while a reload runs. Without
+// a config file the settings the server started with are used.
+func subscriptionConfig() (*viper.Viper, error) {
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		return viper.GetViper(), nil
+	}
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// loadSubscriptions reads the DAs listed under subscriptions.items and in
+// subscriptions.file, a document written like mda export. ok is false when
+// neither is set, the DA table is then left alone. Viper lowercases the
+// keys of the items, parameters whose case matters belong in the file.
+func loadSubscriptions(v *viper.Viper) (subs []service.Subscription, ok bool, err error) {
+	if items := v.Get("subscriptions.items"); items != nil {
+		b, err := yaml.Marshal(items)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := yaml.UnmarshalStrict(b, &subs); err != nil {
+			return nil, false, err
+		}
+		ok = true
+	}
+	if file := v.GetString("subscriptions.file"); file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, false, err
+		}
+		defer f.Close()
+		doc, err := service.DecodeExport(f, service.FormatOf(file))
+		if err != nil {
+			return nil, false, err
+		}
+		subs, ok = append(subs, doc.Subscriptions...), true
+	}
+	return subs, ok, nil
+}
+
+// reconcile brings the DA table in line with the subscriptions config and
+// logs what changed.
+func reconcile(svc service.MdaService, reason string) error {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+	v, err := subscriptionConfig()
+	if err != nil {
+		return err
+	}
+	subs, ok, err := loadSubscriptions(v)
+	if err != nil || !ok {
+		return err
+	}
+	prune := v.GetBool("subscriptions.prune")
+	report, err := svc.Reconcile(context.Background(), subs, prune, v.GetFloat64("subscriptions.max_prune"))
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	for _, c := range report.Changes {
+		counts[c.Action]++
+		fields := log.Fields{"action": c.Action, "name": c.Name, "da": c.ID, "url": c.URL}
+		if len(c.Fields) > 0 {
+			fields["fields"] = strings.Join(c.Fields, ",")
+		}
+		switch {
+		case c.Error != "":
+			log.WithFields(fields).Warn(c.Error)
+		case c.Action == service.ActionUnchanged:
+			log.WithFields(fields).Debug("Subscription unchanged")
+		case c.Action == service.ActionOrphan:
+			log.WithFields(fields).Warn("Subscription is no longer listed, set subscriptions.prune to remove it")
+		default:
+			log.WithFields(fields).Info("Subscription reconciled")
+		}
+	}
+	log.WithFields(log.Fields{"reason": reason, "prune": prune,
+		"created": counts[service.ActionCreate], "updated": counts[service.ActionUpdate],
+		"removed": counts[service.ActionRemove], "unchanged": counts[service.ActionUnchanged],
+		"orphaned": counts[service.ActionOrphan], "invalid": counts[service.ActionInvalid]}).
+		Info("Reconciled subscriptions")
+	return nil
+}
+
+// watchSubscriptions reconciles again on SIGHUP and whenever the config
+// file or subscriptions.file change.
+func watchSubscriptions(svc service.MdaService) {
+	rerun := func(reason string) {
+		if err := reconcile(svc, reason); err != nil {
+			log.WithError(err).WithField("reason", reason).Error("Unable to reconcile subscriptions")
+		}
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			rerun("sighup")
+		}
+	}()
+	files := map[string]string{}
+	if f := viper.ConfigFileUsed(); f != "" {
+		files[filepath.Clean(f)] = "config changed"
+	}
+	if f := viper.GetString("subscriptions.file"); f != "" {
+		files[filepath.Clean(f)] = "subscriptions file changed"
+	}
+	if len(files) == 0 {
+		return
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.WithError(err).Warn("Unable to watch subscriptions")
+		return
+	}
+	// Editors and git replace files, so their directories are watched.
+	for f := range files {
+		if err := w.Add(filepath.Dir(f)); err != nil {
+			log.WithError(err).WithField("file", f).Warn("Unable to watch subscriptions")
+		}
+	}
+	go func() {
+		defer w.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				reason, watched := files[filepath.Clean(e.Name)]
+				if !watched || e.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, func() { rerun(reason) })
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).Warn("Error watching subscriptions")
+			}
+		}
+	}()
+}
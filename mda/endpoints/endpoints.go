@@ -2,9 +2,11 @@ package endpoints
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/will7200/mda/da"
+	"github.com/will7200/mda/mda/feed"
 	"github.com/will7200/mda/mda/service"
 )
 
@@ -13,15 +15,33 @@ import (
 // single parameter.
 
 type Endpoints struct {
-	AddEndpoint     endpoint.Endpoint
-	StartEndpoint   endpoint.Endpoint
-	RemoveEndpoint  endpoint.Endpoint
-	ChangeEndpoint  endpoint.Endpoint
-	GetEndpoint     endpoint.Endpoint
-	ListEndpoint    endpoint.Endpoint
-	EnableEndpoint  endpoint.Endpoint
-	DisableEndpoint endpoint.Endpoint
-	TryEndpoint     endpoint.Endpoint
+	AddEndpoint          endpoint.Endpoint
+	StartEndpoint        endpoint.Endpoint
+	RemoveEndpoint       endpoint.Endpoint
+	ChangeEndpoint       endpoint.Endpoint
+	GetEndpoint          endpoint.Endpoint
+	ListEndpoint         endpoint.Endpoint
+	EnableEndpoint       endpoint.Endpoint
+	DisableEndpoint      endpoint.Endpoint
+	TryEndpoint          endpoint.Endpoint
+	ProvidersEndpoint    endpoint.Endpoint
+	ItemsEndpoint        endpoint.Endpoint
+	SearchEndpoint       endpoint.Endpoint
+	ArchiveEndpoint      endpoint.Endpoint
+	ResetArchiveEndpoint endpoint.Endpoint
+	PruneArchiveEndpoint endpoint.Endpoint
+	RewindEndpoint       endpoint.Endpoint
+	HistoryEndpoint      endpoint.Endpoint
+	ResultsEndpoint      endpoint.Endpoint
+	RetryFailedEndpoint  endpoint.Endpoint
+	CleanEndpoint        endpoint.Endpoint
+	JanitorEndpoint      endpoint.Endpoint
+	FeedEndpoint         endpoint.Endpoint
+	FeedAllEndpoint      endpoint.Endpoint
+	ExportOPMLEndpoint   endpoint.Endpoint
+	ImportOPMLEndpoint   endpoint.Endpoint
+	ExportEndpoint       endpoint.Endpoint
+	ImportEndpoint       endpoint.Endpoint
 }
 type AddRequest struct {
 	Req da.DA
@@ -59,7 +79,9 @@ type GetResponse struct {
 	Result *da.DA
 	Err    error `json:",omitempty"`
 }
-type ListRequest struct{}
+type ListRequest struct {
+	ErrorClass string
+}
 type ListResponse struct {
 	Results *[]da.DA
 	Err     error `json:",omitempty"`
@@ -78,16 +100,248 @@ type DisableResponse struct {
 	Message string
 	Err     error `json:",omitempty"`
 }
+type ProvidersRequest struct{}
+type ProvidersResponse struct {
+	Results []da.Provider
+	Err     error `json:",omitempty"`
+}
+type ItemsRequest struct {
+	Id string
+}
+type ItemsResponse struct {
+	Results []da.MediaItem
+	Err     error `json:",omitempty"`
+}
+type SearchRequest struct {
+	Query string
+}
+type SearchResponse struct {
+	Results []da.MediaItem
+	Err     error `json:",omitempty"`
+}
+type ArchiveRequest struct {
+	Id string
+}
+type ArchiveResponse struct {
+	Results []da.ArchiveEntry
+	Err     error `json:",omitempty"`
+}
+type ResetArchiveRequest struct {
+	Id string
+}
+type ResetArchiveResponse struct {
+	Message string
+	Err     error `json:",omitempty"`
+}
+type PruneArchiveRequest struct {
+	Id string
+}
+type PruneArchiveResponse struct {
+	Message string
+	Err     error `json:",omitempty"`
+}
+type RewindRequest struct {
+	Id string
+	To time.Time
+}
+type RewindResponse struct {
+	Message string
+	Err     error `json:",omitempty"`
+}
+type HistoryRequest struct {
+	Id         string
+	ErrorClass string
+}
+type HistoryResponse struct {
+	Results []da.Stats
+	Err     error `json:",omitempty"`
+}
+type ResultsRequest struct {
+	Session string
+}
+type ResultsResponse struct {
+	Results []da.ItemResult
+	Err     error `json:",omitempty"`
+}
+type RetryFailedRequest struct {
+	Session string
+}
+type RetryFailedResponse struct {
+	Message string
+	Err     error `json:",omitempty"`
+}
+type CleanRequest struct {
+	Id     string
+	DryRun bool
+}
+type CleanResponse struct {
+	Report *da.RetentionReport
+	Err    error `json:",omitempty"`
+}
+type JanitorRequest struct {
+	DryRun bool
+}
+type JanitorResponse struct {
+	Reports []*da.RetentionReport
+	Err     error `json:",omitempty"`
+}
+type FeedRequest struct {
+	Id    string
+	Links feed.Links
+}
+type FeedResponse struct {
+	Result *feed.RSS
+	Err    error `json:",omitempty"`
+}
+type FeedAllRequest struct {
+	Links feed.Links
+}
+type FeedAllResponse struct {
+	Result *feed.RSS
+	Err    error `json:",omitempty"`
+}
+type ExportOPMLRequest struct {
+	Links feed.Links
+}
+type ExportOPMLResponse struct {
+	Result *feed.OPML
+	Err    error `json:",omitempty"`
+}
+type ImportOPMLRequest struct {
+	Doc      feed.OPML
+	Defaults da.DA
+}
+type ImportOPMLResponse struct {
+	Results []service.ImportResult
+	Err     error `json:",omitempty"`
+}
+type ExportRequest struct {
+	Format string
+}
+type ExportResponse struct {
+	Result *service.Export
+	Format string `json:"-"`
+	Err    error  `json:",omitempty"`
+}
+type ImportRequest struct {
+	Doc    service.Export
+	Mode   service.ImportMode
+	DryRun bool
+	Force  bool
+}
+type ImportResponse struct {
+	Result *service.ImportReport
+	Err    error `json:",omitempty"`
+}
 
-func New(svc service.MdaService) (ep Endpoints) {
+// New returns an Endpoints struct where each endpoint invokes the
+// corresponding method on the provided service. Middlewares in mdw are keyed
+// by method name and wrapped in order, so the last one runs first.
+func New(svc service.MdaService, mdw map[string][]endpoint.Middleware) (ep Endpoints) {
 	ep.AddEndpoint = MakeAddEndpoint(svc)
+	for _, m := range mdw["Add"] {
+		ep.AddEndpoint = m(ep.AddEndpoint)
+	}
 	ep.StartEndpoint = MakeStartEndpoint(svc)
+	for _, m := range mdw["Start"] {
+		ep.StartEndpoint = m(ep.StartEndpoint)
+	}
 	ep.RemoveEndpoint = MakeRemoveEndpoint(svc)
+	for _, m := range mdw["Remove"] {
+		ep.RemoveEndpoint = m(ep.RemoveEndpoint)
+	}
 	ep.ChangeEndpoint = MakeChangeEndpoint(svc)
+	for _, m := range mdw["Change"] {
+		ep.ChangeEndpoint = m(ep.ChangeEndpoint)
+	}
 	ep.GetEndpoint = MakeGetEndpoint(svc)
+	for _, m := range mdw["Get"] {
+		ep.GetEndpoint = m(ep.GetEndpoint)
+	}
 	ep.ListEndpoint = MakeListEndpoint(svc)
+	for _, m := range mdw["List"] {
+		ep.ListEndpoint = m(ep.ListEndpoint)
+	}
 	ep.EnableEndpoint = MakeEnableEndpoint(svc)
+	for _, m := range mdw["Enable"] {
+		ep.EnableEndpoint = m(ep.EnableEndpoint)
+	}
 	ep.DisableEndpoint = MakeDisableEndpoint(svc)
+	for _, m := range mdw["Disable"] {
+		ep.DisableEndpoint = m(ep.DisableEndpoint)
+	}
+	ep.ProvidersEndpoint = MakeProvidersEndpoint(svc)
+	for _, m := range mdw["Providers"] {
+		ep.ProvidersEndpoint = m(ep.ProvidersEndpoint)
+	}
+	ep.ItemsEndpoint = MakeItemsEndpoint(svc)
+	for _, m := range mdw["Items"] {
+		ep.ItemsEndpoint = m(ep.ItemsEndpoint)
+	}
+	ep.SearchEndpoint = MakeSearchEndpoint(svc)
+	for _, m := range mdw["Search"] {
+		ep.SearchEndpoint = m(ep.SearchEndpoint)
+	}
+	ep.ArchiveEndpoint = MakeArchiveEndpoint(svc)
+	for _, m := range mdw["Archive"] {
+		ep.ArchiveEndpoint = m(ep.ArchiveEndpoint)
+	}
+	ep.ResetArchiveEndpoint = MakeResetArchiveEndpoint(svc)
+	for _, m := range mdw["ResetArchive"] {
+		ep.ResetArchiveEndpoint = m(ep.ResetArchiveEndpoint)
+	}
+	ep.PruneArchiveEndpoint = MakePruneArchiveEndpoint(svc)
+	for _, m := range mdw["PruneArchive"] {
+		ep.PruneArchiveEndpoint = m(ep.PruneArchiveEndpoint)
+	}
+	ep.RewindEndpoint = MakeRewindEndpoint(svc)
+	for _, m := range mdw["Rewind"] {
+		ep.RewindEndpoint = m(ep.RewindEndpoint)
+	}
+	ep.HistoryEndpoint = MakeHistoryEndpoint(svc)
+	for _, m := range mdw["History"] {
+		ep.HistoryEndpoint = m(ep.HistoryEndpoint)
+	}
+	ep.ResultsEndpoint = MakeResultsEndpoint(svc)
+	for _, m := range mdw["Results"] {
+		ep.ResultsEndpoint = m(ep.ResultsEndpoint)
+	}
+	ep.RetryFailedEndpoint = MakeRetryFailedEndpoint(svc)
+	for _, m := range mdw["RetryFailed"] {
+		ep.RetryFailedEndpoint = m(ep.RetryFailedEndpoint)
+	}
+	ep.CleanEndpoint = MakeCleanEndpoint(svc)
+	for _, m := range mdw["Clean"] {
+		ep.CleanEndpoint = m(ep.CleanEndpoint)
+	}
+	ep.JanitorEndpoint = MakeJanitorEndpoint(svc)
+	for _, m := range mdw["Janitor"] {
+		ep.JanitorEndpoint = m(ep.JanitorEndpoint)
+	}
+	ep.FeedEndpoint = MakeFeedEndpoint(svc)
+	for _, m := range mdw["Feed"] {
+		ep.FeedEndpoint = m(ep.FeedEndpoint)
+	}
+	ep.FeedAllEndpoint = MakeFeedAllEndpoint(svc)
+	for _, m := range mdw["FeedAll"] {
+		ep.FeedAllEndpoint = m(ep.FeedAllEndpoint)
+	}
+	ep.ExportOPMLEndpoint = MakeExportOPMLEndpoint(svc)
+	for _, m := range mdw["ExportOPML"] {
+		ep.ExportOPMLEndpoint = m(ep.ExportOPMLEndpoint)
+	}
+	ep.ImportOPMLEndpoint = MakeImportOPMLEndpoint(svc)
+	for _, m := range mdw["ImportOPML"] {
+		ep.ImportOPMLEndpoint = m(ep.ImportOPMLEndpoint)
+	}
+	ep.ExportEndpoint = MakeExportEndpoint(svc)
+	for _, m := range mdw["Export"] {
+		ep.ExportEndpoint = m(ep.ExportEndpoint)
+	}
+	ep.ImportEndpoint = MakeImportEndpoint(svc)
+	for _, m := range mdw["Import"] {
+		ep.ImportEndpoint = m(ep.ImportEndpoint)
+	}
 	return ep
 }
 
@@ -144,8 +398,9 @@ func MakeGetEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
 // MakeListEndpoint returns an endpoint that invokes List on the service.
 // Primarily useful in a server.
 func MakeListEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
-	return func(ctx context.Context, _ interface{}) (interface{}, error) {
-		results, e := svc.List(ctx)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, _ := request.(ListRequest)
+		results, e := svc.List(ctx, req.ErrorClass)
 		return ListResponse{Results: results, Err: e}, e
 	}
 }
@@ -169,3 +424,182 @@ func MakeDisableEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
 		return DisableResponse{Message: message, Err: err}, err
 	}
 }
+
+// MakeProvidersEndpoint returns an endpoint that invokes Providers on the service.
+// Primarily useful in a server.
+func MakeProvidersEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		results, err := svc.Providers(ctx)
+		return ProvidersResponse{Results: results, Err: err}, err
+	}
+}
+
+// MakeItemsEndpoint returns an endpoint that invokes Items on the service.
+// Primarily useful in a server.
+func MakeItemsEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ItemsRequest)
+		results, err := svc.Items(ctx, req.Id)
+		return ItemsResponse{Results: results, Err: err}, err
+	}
+}
+
+// MakeSearchEndpoint returns an endpoint that invokes Search on the service.
+// Primarily useful in a server.
+func MakeSearchEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SearchRequest)
+		results, err := svc.Search(ctx, req.Query)
+		return SearchResponse{Results: results, Err: err}, err
+	}
+}
+
+// MakeArchiveEndpoint returns an endpoint that invokes Archive on the service.
+// Primarily useful in a server.
+func MakeArchiveEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ArchiveRequest)
+		results, err := svc.Archive(ctx, req.Id)
+		return ArchiveResponse{Results: results, Err: err}, err
+	}
+}
+
+// MakeResetArchiveEndpoint returns an endpoint that invokes ResetArchive on the service.
+// Primarily useful in a server.
+func MakeResetArchiveEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ResetArchiveRequest)
+		message, err := svc.ResetArchive(ctx, req.Id)
+		return ResetArchiveResponse{Message: message, Err: err}, err
+	}
+}
+
+// MakePruneArchiveEndpoint returns an endpoint that invokes PruneArchive on the service.
+// Primarily useful in a server.
+func MakePruneArchiveEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(PruneArchiveRequest)
+		message, err := svc.PruneArchive(ctx, req.Id)
+		return PruneArchiveResponse{Message: message, Err: err}, err
+	}
+}
+
+// MakeRewindEndpoint returns an endpoint that invokes Rewind on the service.
+// Primarily useful in a server.
+func MakeRewindEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RewindRequest)
+		message, err := svc.Rewind(ctx, req.Id, req.To)
+		return RewindResponse{Message: message, Err: err}, err
+	}
+}
+
+// MakeHistoryEndpoint returns an endpoint that invokes History on the service.
+// Primarily useful in a server.
+func MakeHistoryEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(HistoryRequest)
+		results, err := svc.History(ctx, req.Id, req.ErrorClass)
+		return HistoryResponse{Results: results, Err: err}, err
+	}
+}
+
+// MakeResultsEndpoint returns an endpoint that invokes Results on the service.
+// Primarily useful in a server.
+func MakeResultsEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ResultsRequest)
+		results, err := svc.Results(ctx, req.Session)
+		return ResultsResponse{Results: results, Err: err}, err
+	}
+}
+
+// MakeRetryFailedEndpoint returns an endpoint that invokes RetryFailed on the
+// service. Primarily useful in a server.
+func MakeRetryFailedEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RetryFailedRequest)
+		message, err := svc.RetryFailed(ctx, req.Session)
+		return RetryFailedResponse{Message: message, Err: err}, err
+	}
+}
+
+// MakeCleanEndpoint returns an endpoint that invokes Clean on the service.
+// Primarily useful in a server.
+func MakeCleanEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CleanRequest)
+		report, err := svc.Clean(ctx, req.Id, req.DryRun)
+		return CleanResponse{Report: report, Err: err}, err
+	}
+}
+
+// MakeJanitorEndpoint returns an endpoint that invokes Janitor on the service.
+// Primarily useful in a server.
+func MakeJanitorEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(JanitorRequest)
+		reports, err := svc.Janitor(ctx, req.DryRun)
+		return JanitorResponse{Reports: reports, Err: err}, err
+	}
+}
+
+// MakeFeedEndpoint returns an endpoint that invokes Feed on the service.
+// Primarily useful in a server.
+func MakeFeedEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(FeedRequest)
+		result, err := svc.Feed(ctx, req.Id, req.Links)
+		return FeedResponse{Result: result, Err: err}, err
+	}
+}
+
+// MakeFeedAllEndpoint returns an endpoint that invokes FeedAll on the service.
+// Primarily useful in a server.
+func MakeFeedAllEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(FeedAllRequest)
+		result, err := svc.FeedAll(ctx, req.Links)
+		return FeedAllResponse{Result: result, Err: err}, err
+	}
+}
+
+// MakeExportOPMLEndpoint returns an endpoint that invokes ExportOPML on the
+// service. Primarily useful in a server.
+func MakeExportOPMLEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ExportOPMLRequest)
+		result, err := svc.ExportOPML(ctx, req.Links)
+		return ExportOPMLResponse{Result: result, Err: err}, err
+	}
+}
+
+// MakeImportOPMLEndpoint returns an endpoint that invokes ImportOPML on the
+// service. Primarily useful in a server.
+func MakeImportOPMLEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ImportOPMLRequest)
+		results, err := svc.ImportOPML(ctx, req.Doc, req.Defaults)
+		return ImportOPMLResponse{Results: results, Err: err}, err
+	}
+}
+
+// MakeExportEndpoint returns an endpoint that invokes Export on the service.
+// Primarily useful in a server.
+func MakeExportEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ExportRequest)
+		result, err := svc.Export(ctx)
+		return ExportResponse{Result: result, Format: req.Format, Err: err}, err
+	}
+}
+
+// MakeImportEndpoint returns an endpoint that invokes Import on the service.
+// Primarily useful in a server.
+func MakeImportEndpoint(svc service.MdaService) (ep endpoint.Endpoint) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ImportRequest)
+		result, err := svc.Import(ctx, req.Doc, req.Mode, req.DryRun, req.Force)
+		return ImportResponse{Result: result, Err: err}, err
+	}
+}
@@ -0,0 +1,316 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	httptransport "github.com/go-kit/kit/transport/http"
+	log "github.com/sirupsen/logrus"
+	"github.com/will7200/mda/mda/auth"
+	"github.com/will7200/mda/mda/callback"
+	"github.com/will7200/mda/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/peer"
+)
+
+var ErrRateLimited = errors.New("Too many requests, please slow down")
+
+// callerIdle is how long a caller's limiter is kept after its last request.
+const callerIdle = 10 * time.Minute
+
+// CallbackMiddleware verifies the signed token the remote scheduler sends
+// with StartRequest. A valid token authenticates the call as the scheduler,
+// with admin scope so the DA is found whoever owns it, the token is only good
+// for starting that one DA. An invalid token rejects the call. Calls without a token are manual starts, they
+// are passed on to be authenticated like any other call.
+func CallbackMiddleware(v *callback.Verifier) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			token, ok := callback.FromContext(ctx)
+			if !ok {
+				return next(ctx, request)
+			}
+			req, ok := request.(StartRequest)
+			if !ok {
+				return nil, callback.ErrTokenInvalid
+			}
+			if err := v.Verify(req.Id, token); err != nil {
+				return nil, err
+			}
+			ctx = auth.NewContext(ctx, &auth.APIKey{Owner: "scheduler", Scopes: string(auth.ScopeAdmin)})
+			return next(ctx, request)
+		}
+	}
+}
+
+// RateLimitMiddleware rejects requests with ErrRateLimited once limiter is
+// exhausted. One limiter is shared by every caller.
+func RateLimitMiddleware(limiter *rate.Limiter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if !limiter.Allow() {
+				return nil, ErrRateLimited
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// CallerRateLimitMiddleware gives every caller its own limiter of r requests
+// per second with burst. anonymous is meant to run before authentication, so
+// unauthenticated floods do not reach the key lookup, and limits the calls
+// that carry no API key by the address ClientAddr finds with proxies. keyed
+// runs after authentication and limits the others by their key, so callers
+// behind one address do not share a limiter. Both are needed, anonymous
+// leaves calls with a key to keyed.
+func CallerRateLimitMiddleware(r rate.Limit, burst int, proxies Proxies) (anonymous, keyed endpoint.Middleware) {
+	type entry struct {
+		limiter *rate.Limiter
+		seen    time.Time
+	}
+	var (
+		mu      sync.Mutex
+		callers = make(map[string]*entry)
+		swept   = time.Now()
+	)
+	allow := func(caller string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		if now.Sub(swept) > callerIdle {
+			for k, e := range callers {
+				if now.Sub(e.seen) > callerIdle {
+					delete(callers, k)
+				}
+			}
+			swept = now
+		}
+		e, ok := callers[caller]
+		if !ok {
+			e = &entry{limiter: rate.NewLimiter(r, burst)}
+			callers[caller] = e
+		}
+		e.seen = now
+		return e.limiter.Allow()
+	}
+	anonymous = func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if _, ok := auth.FromContext(ctx); !ok && !auth.HasToken(ctx) && !allow("addr:"+ClientAddr(ctx, proxies)) {
+				return nil, ErrRateLimited
+			}
+			return next(ctx, request)
+		}
+	}
+	keyed = func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			caller := ""
+			if k, ok := auth.FromContext(ctx); ok {
+				caller = "key:" + k.ID
+			} else if auth.HasToken(ctx) {
+				// Nothing checked the key, auth is off.
+				caller = "addr:" + ClientAddr(ctx, proxies)
+			}
+			if caller != "" && !allow(caller) {
+				return nil, ErrRateLimited
+			}
+			return next(ctx, request)
+		}
+	}
+	return anonymous, keyed
+}
+
+// Caller identifies who made the request in ctx.
+func Caller(ctx context.Context) string {
+	if k, ok := auth.FromContext(ctx); ok {
+		return "key:" + k.ID
+	}
+	if addr := ClientAddr(ctx, nil); addr != "" {
+		return "addr:" + addr
+	}
+	return "unknown"
+}
+
+// Proxies are the networks of the reverse proxies whose X-Forwarded-For is
+// believed.
+type Proxies []*net.IPNet
+
+// ParseProxies reads addresses and CIDR networks.
+func ParseProxies(list []string) (Proxies, error) {
+	proxies := make(Proxies, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("Trusted proxy %q is not an address or network", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("Trusted proxy %q is not an address or network", s)
+		}
+		proxies = append(proxies, n)
+	}
+	return proxies, nil
+}
+
+func (p Proxies) contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddr is the address the request in ctx came from. X-Forwarded-For
+// is only followed while the hop that added it is one of proxies, the first
+// address that is not a trusted proxy is the client.
+func ClientAddr(ctx context.Context, proxies Proxies) string {
+	addr := ""
+	if a, ok := ctx.Value(httptransport.ContextKeyRequestRemoteAddr).(string); ok && a != "" {
+		addr = hostOnly(a)
+	} else if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return hostOnly(p.Addr.String())
+	}
+	fwd, _ := ctx.Value(httptransport.ContextKeyRequestXForwardedFor).(string)
+	hops := strings.Split(fwd, ",")
+	for i := len(hops) - 1; i >= 0 && proxies.contains(addr); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			break
+		}
+		addr = hostOnly(hop)
+	}
+	return addr
+}
+
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// InstrumentingMiddleware records the number of calls and their latency,
+// labelled by method and whether the call failed.
+func InstrumentingMiddleware(method string, requests metrics.Counter, duration metrics.Histogram) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				lvs := []string{"method", method, "error", fmt.Sprint(err != nil)}
+				requests.With(lvs...).Add(1)
+				duration.With(lvs...).Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// LoggingMiddleware writes one structured line per call. It also makes sure
+// the context carries a request id, taken from X-Request-ID when the caller
+// sent one.
+func LoggingMiddleware(method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			rid := tracing.RequestID(ctx)
+			if rid == "" {
+				rid, _ = ctx.Value(httptransport.ContextKeyRequestXRequestID).(string)
+				if rid == "" {
+					rid = tracing.NewRequestID()
+				}
+				ctx = tracing.WithRequestID(ctx, rid)
+			}
+			defer func(begin time.Time) {
+				entry := log.WithFields(log.Fields{
+					"method":     method,
+					"id":         requestedID(request),
+					"request_id": rid,
+					"caller":     Caller(ctx),
+					"duration":   time.Since(begin).String(),
+				})
+				if err != nil {
+					entry.WithError(err).Warn("request failed")
+					return
+				}
+				entry.Info("request handled")
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// TracingMiddleware wraps every call in a span named after method.
+func TracingMiddleware(method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			ctx, span := tracing.Tracer().Start(ctx, "endpoint."+method)
+			defer func() {
+				span.SetAttributes(attribute.String("mda.request_id", tracing.RequestID(ctx)))
+				if id := requestedID(request); id != "" {
+					span.SetAttributes(attribute.String("mda.id", id))
+				}
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+				}
+				span.End()
+			}()
+			return next(ctx, request)
+		}
+	}
+}
+
+// requestedID returns the DA id a request refers to, if any.
+func requestedID(request interface{}) string {
+	switch r := request.(type) {
+	case StartRequest:
+		return r.Id
+	case RemoveRequest:
+		return r.Id
+	case ChangeRequest:
+		return r.Id
+	case GetRequest:
+		return r.Id
+	case EnableRequest:
+		return r.Id
+	case DisableRequest:
+		return r.Id
+	case ItemsRequest:
+		return r.Id
+	case ArchiveRequest:
+		return r.Id
+	case ResetArchiveRequest:
+		return r.Id
+	case PruneArchiveRequest:
+		return r.Id
+	case RewindRequest:
+		return r.Id
+	case HistoryRequest:
+		return r.Id
+	case CleanRequest:
+		return r.Id
+	case FeedRequest:
+		return r.Id
+	}
+	return ""
+}
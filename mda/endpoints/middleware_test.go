@@ -0,0 +1,243 @@
+package endpoints
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/will7200/mda/da"
+	"github.com/will7200/mda/mda/auth"
+	"github.com/will7200/mda/mda/callback"
+	"github.com/will7200/mda/mda/service"
+	"golang.org/x/time/rate"
+)
+
+func TestClientAddr(t *testing.T) {
+	proxies, err := ParseProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		remote  string
+		fwd     string
+		proxies Proxies
+		want    string
+	}{
+		{"direct", "203.0.113.7:4000", "", proxies, "203.0.113.7"},
+		{"spoofed without proxy", "203.0.113.7:4000", "1.2.3.4", proxies, "203.0.113.7"},
+		{"no trusted proxies", "10.0.0.2:4000", "1.2.3.4", nil, "10.0.0.2"},
+		{"through proxy", "10.0.0.2:4000", "198.51.100.9", proxies, "198.51.100.9"},
+		{"spoofed through proxy", "10.0.0.2:4000", "1.2.3.4, 198.51.100.9", proxies, "198.51.100.9"},
+		{"proxy chain", "10.0.0.2:4000", "198.51.100.9, 192.168.1.1", proxies, "198.51.100.9"},
+		{"only proxies", "10.0.0.2:4000", "10.0.0.3", proxies, "10.0.0.3"},
+	}
+	for _, tt := range tests {
+		ctx := context.WithValue(context.Background(), httptransport.ContextKeyRequestRemoteAddr, tt.remote)
+		if tt.fwd != "" {
+			ctx = context.WithValue(ctx, httptransport.ContextKeyRequestXForwardedFor, tt.fwd)
+		}
+		if got := ClientAddr(ctx, tt.proxies); got != tt.want {
+			t.Errorf("%s: ClientAddr() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	if _, err := ParseProxies([]string{"10.0.0.0/8", "::1", " 127.0.0.1 "}); err != nil {
+		t.Errorf("ParseProxies() = %v", err)
+	}
+	for _, bad := range []string{"proxy", "10.0.0.0/33", ""} {
+		if _, err := ParseProxies([]string{bad}); err == nil {
+			t.Errorf("ParseProxies(%q) accepted", bad)
+		}
+	}
+}
+
+func TestCallbackMiddleware(t *testing.T) {
+	secret := []byte("s3cret")
+	v := callback.NewVerifier(secret, time.Minute, nil)
+	signed := func(id string) string {
+		token, err := callback.NewToken(secret, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	replayed := signed("da-1")
+	if err := v.Verify("da-1", replayed); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		token     string
+		id        string
+		want      error
+		scheduler bool
+	}{
+		{"manual start", "", "da-1", nil, false},
+		{"signed", signed("da-1"), "da-1", nil, true},
+		{"other id", signed("da-2"), "da-1", callback.ErrTokenInvalid, false},
+		{"replayed", replayed, "da-1", callback.ErrTokenReplayed, false},
+		{"garbage", "token", "da-1", callback.ErrTokenInvalid, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/start/"+tt.id, nil)
+		if tt.token != "" {
+			r.Header.Set(callback.Header, tt.token)
+		}
+		ctx := callback.HTTPToContext(context.Background(), r)
+		scheduler := false
+		next := func(ctx context.Context, request interface{}) (interface{}, error) {
+			k, ok := auth.FromContext(ctx)
+			scheduler = ok && k.Owner == "scheduler" && k.Allows(auth.ScopeAdmin)
+			return nil, nil
+		}
+		_, err := CallbackMiddleware(v)(next)(ctx, StartRequest{Id: tt.id})
+		if err != tt.want {
+			t.Errorf("%s: CallbackMiddleware() = %v, want %v", tt.name, err, tt.want)
+		}
+		if scheduler != tt.scheduler {
+			t.Errorf("%s: authenticated as scheduler = %v, want %v", tt.name, scheduler, tt.scheduler)
+		}
+	}
+}
+
+// startRecorder is a Downloader that records the DAs it is asked to start.
+type startRecorder struct {
+	da.Downloader
+	started []string
+}
+
+func (s *startRecorder) Add(ctx context.Context, d *da.DA) error {
+	s.started = append(s.started, d.ID)
+	return nil
+}
+
+func TestStartCallback(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.DB().SetMaxOpenConns(1)
+	da.CreateDatabaseTables(db)
+	db.AutoMigrate(&auth.APIKey{})
+	d := da.DA{URL: "https://example.com/ann", Owner: "ann"}
+	if err := db.Create(&d).Error; err != nil {
+		t.Fatal(err)
+	}
+	store := auth.NewStore(db)
+	ann, _, err := store.Create("ann", []auth.Scope{auth.ScopeWrite}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _, err := store.Create("bob", []auth.Scope{auth.ScopeWrite}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("s3cret")
+	token, err := callback.NewToken(secret, d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloader := &startRecorder{}
+	// Wired like getEndpointMiddleware, the callback runs before auth.
+	start := New(service.New(db, downloader, da.DefaultProviders(), da.Layout{}), map[string][]endpoint.Middleware{
+		"Start": {auth.Middleware(store, auth.ScopeWrite), CallbackMiddleware(callback.NewVerifier(secret, time.Minute, nil))},
+	}).StartEndpoint
+	tests := []struct {
+		name  string
+		token string
+		key   string
+		want  error
+	}{
+		{"signed callback", token, "", nil},
+		{"owner", "", ann, nil},
+		{"other owner", "", bob, service.ErrDaDNE},
+		{"anonymous", "", "", auth.ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/mda/start/"+d.ID, nil)
+		if tt.token != "" {
+			r.Header.Set(callback.Header, tt.token)
+		}
+		if tt.key != "" {
+			r = authorized(tt.key)
+		}
+		ctx := auth.HTTPToContext(callback.HTTPToContext(context.Background(), r), r)
+		downloader.started = nil
+		if _, err := start(ctx, StartRequest{Id: d.ID}); err != tt.want {
+			t.Errorf("%s: Start() = %v, want %v", tt.name, err, tt.want)
+		}
+		if started := len(downloader.started) == 1; started != (tt.want == nil) {
+			t.Errorf("%s: Start() started %v", tt.name, downloader.started)
+		}
+	}
+}
+
+func TestCallerRateLimitMiddleware(t *testing.T) {
+	anonymous, keyed := CallerRateLimitMiddleware(rate.Every(time.Hour), 1, nil)
+	next := func(ctx context.Context, request interface{}) (interface{}, error) { return nil, nil }
+	// Auth runs between the two, stood in for by the key of the caller.
+	call := func(addr string, k *auth.APIKey) error {
+		ctx := context.WithValue(context.Background(), httptransport.ContextKeyRequestRemoteAddr, addr+":4000")
+		e := keyed(next)
+		if k != nil {
+			ctx = auth.HTTPToContext(ctx, authorized("mda_"+k.ID))
+			inner := e
+			e = func(ctx context.Context, request interface{}) (interface{}, error) {
+				return inner(auth.NewContext(ctx, k), request)
+			}
+		}
+		_, err := anonymous(e)(ctx, nil)
+		return err
+	}
+	ann, bob := &auth.APIKey{ID: "ann"}, &auth.APIKey{ID: "bob"}
+	tests := []struct {
+		name string
+		addr string
+		key  *auth.APIKey
+		want error
+	}{
+		{"first anonymous call", "192.0.2.1", nil, nil},
+		{"anonymous again", "192.0.2.1", nil, ErrRateLimited},
+		{"anonymous elsewhere", "192.0.2.2", nil, nil},
+		{"key behind a limited address", "192.0.2.1", ann, nil},
+		{"same key", "192.0.2.3", ann, ErrRateLimited},
+		{"other key behind the same address", "192.0.2.1", bob, nil},
+	}
+	for _, tt := range tests {
+		if err := call(tt.addr, tt.key); err != tt.want {
+			t.Errorf("%s: CallerRateLimitMiddleware() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCallerRateLimitMiddlewareAuthOff(t *testing.T) {
+	anonymous, keyed := CallerRateLimitMiddleware(rate.Every(time.Hour), 1, nil)
+	next := func(ctx context.Context, request interface{}) (interface{}, error) { return nil, nil }
+	e := anonymous(keyed(next))
+	ctx := context.WithValue(context.Background(), httptransport.ContextKeyRequestRemoteAddr, "192.0.2.1:4000")
+	// Without auth a made up key must not get past the limiter.
+	ctx = auth.HTTPToContext(ctx, authorized("mda_made_up"))
+	if _, err := e(ctx, nil); err != nil {
+		t.Fatalf("first call = %v", err)
+	}
+	if _, err := e(ctx, nil); err != ErrRateLimited {
+		t.Errorf("second call with an unchecked key = %v, want %v", err, ErrRateLimited)
+	}
+}
+
+// authorized is a request carrying key.
+func authorized(key string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	return r
+}
@@ -0,0 +1,250 @@
+package feed
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/will7200/mda/da"
+)
+
+const (
+	itunesNS = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+	atomNS   = "http://www.w3.org/2005/Atom"
+)
+
+// RSS is an RSS 2.0 podcast feed with the iTunes tags podcast apps read.
+type RSS struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	ITunes  string   `xml:"xmlns:itunes,attr"`
+	Atom    string   `xml:"xmlns:atom,attr"`
+	Channel Channel  `xml:"channel"`
+}
+
+type Channel struct {
+	Title         string   `xml:"title"`
+	Link          string   `xml:"link"`
+	Self          AtomLink `xml:"atom:link"`
+	Description   string   `xml:"description"`
+	Generator     string   `xml:"generator"`
+	LastBuildDate string   `xml:"lastBuildDate,omitempty"`
+	Author        string   `xml:"itunes:author,omitempty"`
+	Summary       string   `xml:"itunes:summary,omitempty"`
+	Image         *Image   `xml:"itunes:image,omitempty"`
+	Explicit      string   `xml:"itunes:explicit"`
+	Items         []Item   `xml:"item"`
+}
+
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type Image struct {
+	Href string `xml:"href,attr"`
+}
+
+type Item struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link,omitempty"`
+	Description string    `xml:"description,omitempty"`
+	GUID        GUID      `xml:"guid"`
+	PubDate     string    `xml:"pubDate,omitempty"`
+	Enclosure   Enclosure `xml:"enclosure"`
+	Author      string    `xml:"itunes:author,omitempty"`
+	Duration    string    `xml:"itunes:duration,omitempty"`
+	Image       *Image    `xml:"itunes:image,omitempty"`
+}
+
+type GUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type Enclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// Links builds the URLs a feed points at. Base is the scheme and host mda
+// is reached on. Key, when set, is added to every URL so apps that can not
+// send headers can use a server with auth enabled.
+type Links struct {
+	Base string
+	Key  string
+}
+
+func (l Links) url(p string) string {
+	u := strings.TrimRight(l.Base, "/") + p
+	if l.Key != "" {
+		u += "?key=" + url.QueryEscape(l.Key)
+	}
+	return u
+}
+
+// File is the URL the file server serves rel on, a slash separated path
+// relative to the home directory.
+func (l Links) File(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return l.url("/files/" + strings.Join(parts, "/"))
+}
+
+// Feed is the URL of the feed of the DA with id.
+func (l Links) Feed(id string) string {
+	return l.url("/feeds/" + url.PathEscape(id) + ".xml")
+}
+
+// All is the URL of the feed of every subscription of the caller.
+func (l Links) All() string {
+	return l.url("/feeds/all.xml")
+}
+
+// mimeTypes are the enclosure types podcast apps expect, other extensions
+// are looked up with the mime package.
+var mimeTypes = map[string]string{
+	".m4a":  "audio/x-m4a",
+	".mp3":  "audio/mpeg",
+	".aac":  "audio/aac",
+	".opus": "audio/ogg",
+	".ogg":  "audio/ogg",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+}
+
+// MimeType is the type enclosures and the file server give path.
+func MimeType(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if t, ok := mimeTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// New builds the feed of d from its indexed items, newest first. Items are
+// served from home by the file server, or from their storage when it is
+// reachable over http and the local copy is gone. Items that are neither
+// are left out.
+func New(d *da.DA, items []da.MediaItem, home string, links Links) *RSS {
+	title := d.Name
+	if title == "" {
+		title = d.URL
+	}
+	c := channel(title, d.URL, links.Feed(d.ID), "Downloads of "+d.URL)
+	c.add(items, home, links)
+	if len(c.Items) > 0 && c.Items[0].Author != "" {
+		// The newest item names the channel, the slug is only a fallback.
+		c.Title, c.Author = c.Items[0].Author, c.Items[0].Author
+	}
+	return &RSS{Version: "2.0", ITunes: itunesNS, Atom: atomNS, Channel: *c}
+}
+
+// All builds the feed merging the items of several DAs, like New.
+func All(items []da.MediaItem, home string, links Links) *RSS {
+	c := channel("mda", links.Base, links.All(), "Downloads of all subscriptions")
+	c.add(items, home, links)
+	return &RSS{Version: "2.0", ITunes: itunesNS, Atom: atomNS, Channel: *c}
+}
+
+func channel(title, link, self, description string) *Channel {
+	return &Channel{
+		Title:       title,
+		Link:        link,
+		Self:        AtomLink{Href: self, Rel: "self", Type: "application/rss+xml"},
+		Description: description,
+		Summary:     description,
+		Generator:   "mda",
+		Explicit:    "false",
+		Items:       []Item{},
+	}
+}
+
+// add appends items to c newest first, the newest one gives c its image
+// and build date.
+func (c *Channel) add(items []da.MediaItem, home string, links Links) {
+	sort.SliceStable(items, func(i, k int) bool { return published(items[i]).After(published(items[k])) })
+	// Item paths are absolute, a relative home would never contain them.
+	if abs, err := filepath.Abs(home); err == nil {
+		home = abs
+	}
+	for _, m := range items {
+		enclosure, ok := enclose(m, home, links)
+		if !ok {
+			continue
+		}
+		item := Item{
+			Title:       m.Title,
+			Link:        m.URL,
+			Description: m.Description,
+			GUID:        GUID{Value: m.ID},
+			PubDate:     published(m).Format(time.RFC1123Z),
+			Enclosure:   enclosure,
+			Author:      m.Uploader,
+			Duration:    duration(m.Duration),
+		}
+		if m.Thumbnail != "" {
+			item.Image = &Image{Href: m.Thumbnail}
+		}
+		if len(c.Items) == 0 {
+			c.Image = item.Image
+			c.LastBuildDate = item.PubDate
+		}
+		c.Items = append(c.Items, item)
+	}
+}
+
+// enclose returns the enclosure of m.
+func enclose(m da.MediaItem, home string, links Links) (Enclosure, bool) {
+	name := m.Path
+	if name == "" {
+		name = m.StorageKey
+	}
+	e := Enclosure{Length: m.Size, Type: MimeType(name)}
+	rel, err := filepath.Rel(home, m.Path)
+	if err == nil && !strings.HasPrefix(rel, "..") {
+		if fi, err := os.Stat(m.Path); err == nil && !fi.IsDir() {
+			e.URL, e.Length = links.File(filepath.ToSlash(rel)), fi.Size()
+			return e, true
+		}
+	}
+	if strings.HasPrefix(m.StorageURL, "http://") || strings.HasPrefix(m.StorageURL, "https://") {
+		e.URL = m.StorageURL
+		return e, true
+	}
+	return e, false
+}
+
+// published is when m was uploaded, or indexed when that is unknown.
+func published(m da.MediaItem) time.Time {
+	if m.UploadDate != nil {
+		return *m.UploadDate
+	}
+	return m.CreatedAt
+}
+
+// duration formats seconds as the HH:MM:SS itunes:duration expects.
+func duration(seconds float64) string {
+	if seconds <= 0 {
+		return ""
+	}
+	s := int(seconds)
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+}
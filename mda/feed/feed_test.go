@@ -0,0 +1,84 @@
+package feed
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/will7200/mda/da"
+)
+
+func TestNewTitle(t *testing.T) {
+	item := da.MediaItem{ID: "a", Uploader: "Some Channel", StorageURL: "https://cdn.example.com/a.mp4"}
+	tests := []struct {
+		name  string
+		d     da.DA
+		items []da.MediaItem
+		want  string
+	}{
+		{"uploader", da.DA{ID: "1", Name: "some-channel", URL: "https://example.com/c"}, []da.MediaItem{item}, "Some Channel"},
+		{"name without items", da.DA{ID: "1", Name: "some-channel", URL: "https://example.com/c"}, nil, "some-channel"},
+		{"url without name", da.DA{ID: "1", URL: "https://example.com/c"}, nil, "https://example.com/c"},
+	}
+	for _, tt := range tests {
+		got := New(&tt.d, tt.items, "/home", Links{Base: "http://mda"}).Channel.Title
+		if got != tt.want {
+			t.Errorf("%s: New() title = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewEnclosures(t *testing.T) {
+	// The default home is relative, ./mda/, while items store absolute paths.
+	home, err := ioutil.TempDir(".", "home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	abs, err := filepath.Abs(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(abs, "show"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(abs, "show", "ep 1.mp3")
+	if err := ioutil.WriteFile(local, []byte("mp3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside, err := ioutil.TempFile("", "ep*.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside.Close()
+	defer os.Remove(outside.Name())
+	tests := []struct {
+		name string
+		item da.MediaItem
+		want string
+	}{
+		{"local", da.MediaItem{Path: local, Size: 1}, "http://mda/files/show/ep%201.mp3?key=k"},
+		{"gone locally", da.MediaItem{Path: filepath.Join(abs, "gone.mp3"), StorageURL: "https://cdn.example.com/gone.mp3"}, "https://cdn.example.com/gone.mp3"},
+		{"outside home", da.MediaItem{Path: outside.Name(), StorageURL: "https://cdn.example.com/out.mp3"}, "https://cdn.example.com/out.mp3"},
+		{"only stored", da.MediaItem{StorageKey: "show/ep2.mp3", StorageURL: "https://cdn.example.com/ep2.mp3"}, "https://cdn.example.com/ep2.mp3"},
+		{"not served", da.MediaItem{Path: filepath.Join(abs, "gone.mp3"), StorageURL: "file:///srv/gone.mp3"}, ""},
+	}
+	for _, tt := range tests {
+		tt.item.ID = tt.name
+		items := New(&da.DA{ID: "1"}, []da.MediaItem{tt.item}, home, Links{Base: "http://mda", Key: "k"}).Channel.Items
+		if tt.want == "" {
+			if len(items) != 0 {
+				t.Errorf("%s: New() items = %v, want none", tt.name, items)
+			}
+			continue
+		}
+		if len(items) != 1 || items[0].Enclosure.URL != tt.want {
+			t.Errorf("%s: New() items = %v, want the enclosure %s", tt.name, items, tt.want)
+			continue
+		}
+		if tt.name == "local" && (items[0].Enclosure.Length != 3 || items[0].Enclosure.Type != "audio/mpeg") {
+			t.Errorf("%s: New() enclosure = %+v", tt.name, items[0].Enclosure)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package feed
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// FileServer serves the files under home that feeds point at. Range
+// requests are answered through http.ServeContent. Directories are not
+// listed and hidden files, like the download archives, are not served.
+func FileServer(home string) http.Handler {
+	dir := http.Dir(home)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		for _, part := range strings.Split(name, "/") {
+			if strings.HasPrefix(part, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		f, err := dir.Open(name)
+		if err != nil {
+			if os.IsPermission(err) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", MimeType(name))
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+	})
+}
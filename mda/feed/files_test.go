@@ -0,0 +1,69 @@
+package feed
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileServer(t *testing.T) {
+	home, err := ioutil.TempDir("", "mda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	for name, content := range map[string]string{
+		"show/ep1.mp3":     "0123456789",
+		".archive":         "youtube abc",
+		"show/.hidden.mp3": "hidden",
+		".cache/ep2.mp3":   "cached",
+	} {
+		p := filepath.Join(home, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := FileServer(home)
+	tests := []struct {
+		name       string
+		path       string
+		rng        string
+		wantStatus int
+		wantBody   string
+	}{
+		{"file", "/show/ep1.mp3", "", http.StatusOK, "0123456789"},
+		{"range", "/show/ep1.mp3", "bytes=2-5", http.StatusPartialContent, "2345"},
+		{"open range", "/show/ep1.mp3", "bytes=7-", http.StatusPartialContent, "789"},
+		{"unsatisfiable", "/show/ep1.mp3", "bytes=20-", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"hidden file", "/.archive", "", http.StatusNotFound, ""},
+		{"hidden in directory", "/show/.hidden.mp3", "", http.StatusNotFound, ""},
+		{"hidden directory", "/.cache/ep2.mp3", "", http.StatusNotFound, ""},
+		{"hidden through dot dot", "/show/../.archive", "", http.StatusNotFound, ""},
+		{"directory", "/show/", "", http.StatusNotFound, ""},
+		{"missing", "/show/ep3.mp3", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://mda"+tt.path, nil)
+		if tt.rng != "" {
+			r.Header.Set("Range", tt.rng)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: GET %s = %d, want %d", tt.name, tt.path, w.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+			t.Errorf("%s: GET %s = %q, want %q", tt.name, tt.path, w.Body.String(), tt.wantBody)
+		}
+		if tt.wantStatus < 300 && w.Header().Get("Content-Type") != "audio/mpeg" {
+			t.Errorf("%s: GET %s Content-Type = %q", tt.name, tt.path, w.Header().Get("Content-Type"))
+		}
+	}
+}
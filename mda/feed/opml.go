@@ -0,0 +1,101 @@
+package feed
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/will7200/mda/da"
+)
+
+// OPML is a subscription list podcast apps and other mda servers read.
+// Outlines point podcast apps at the feeds of mda, the mda attributes keep
+// what another mda server needs to download the same things.
+type OPML struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    OPMLHead `xml:"head"`
+	Body    OPMLBody `xml:"body"`
+}
+
+type OPMLHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type OPMLBody struct {
+	Outlines []Outline `xml:"outline"`
+}
+
+type Outline struct {
+	Text      string    `xml:"text,attr"`
+	Title     string    `xml:"title,attr,omitempty"`
+	Type      string    `xml:"type,attr,omitempty"`
+	XMLURL    string    `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL   string    `xml:"htmlUrl,attr,omitempty"`
+	Source    string    `xml:"mdaSource,attr,omitempty"`
+	Location  string    `xml:"mdaLocation,attr,omitempty"`
+	Frequency string    `xml:"mdaFrequency,attr,omitempty"`
+	Startdate string    `xml:"mdaStartdate,attr,omitempty"`
+	Outlines  []Outline `xml:"outline"`
+}
+
+// opmlDate is the format of mdaStartdate.
+const opmlDate = "2006-01-02"
+
+// NewOPML lists das, each pointing at its feed.
+func NewOPML(das []da.DA, links Links) *OPML {
+	doc := &OPML{Version: "2.0", Head: OPMLHead{Title: "mda subscriptions", DateCreated: time.Now().Format(time.RFC1123Z)}}
+	for _, d := range das {
+		title := d.Name
+		if title == "" {
+			title = d.URL
+		}
+		o := Outline{
+			Text:      title,
+			Title:     title,
+			Type:      "rss",
+			XMLURL:    links.Feed(d.ID),
+			HTMLURL:   d.URL,
+			Source:    d.URL,
+			Location:  d.Location,
+			Frequency: d.Frequency,
+		}
+		if d.Startdate != nil && !d.Startdate.IsZero() {
+			o.Startdate = d.Startdate.Format(opmlDate)
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, o)
+	}
+	return doc
+}
+
+// Subscriptions returns the outlines of doc that can be subscribed to,
+// those nested in categories included.
+func (doc *OPML) Subscriptions() []Outline {
+	var subs []Outline
+	var walk func([]Outline)
+	walk = func(outlines []Outline) {
+		for _, o := range outlines {
+			if o.URL() != "" {
+				subs = append(subs, o)
+			}
+			walk(o.Outlines)
+		}
+	}
+	walk(doc.Body.Outlines)
+	return subs
+}
+
+// URL is what a DA created from o downloads: the source an mda server
+// exported or else the feed itself.
+func (o Outline) URL() string {
+	if o.Source != "" {
+		return o.Source
+	}
+	return o.XMLURL
+}
+
+// Start is the mdaStartdate of o, when it has a valid one.
+func (o Outline) Start() (time.Time, bool) {
+	t, err := time.Parse(opmlDate, o.Startdate)
+	return t, err == nil
+}
@@ -5,9 +5,13 @@ import (
 	"errors"
 
 	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"github.com/will7200/mda/da"
+	"github.com/will7200/mda/mda/auth"
 	"github.com/will7200/mda/mda/endpoints"
 	"github.com/will7200/mda/mda/grpc/pb"
 	oldcontext "golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type grpcServer struct {
@@ -23,58 +27,82 @@ type grpcServer struct {
 
 // MakeGRPCServer makes a set of endpoints available as a gRPC server.
 func MakeGRPCServer(endpoints endpoints.Endpoints) (req pb.MdaServer) {
+	opts := []grpctransport.ServerOption{
+		grpctransport.ServerBefore(auth.GRPCToContext),
+	}
 	req = &grpcServer{
 		add: grpctransport.NewServer(
 			endpoints.AddEndpoint,
 			DecodeGRPCAddRequest,
 			EncodeGRPCAddResponse,
+			opts...,
 		),
 
 		start: grpctransport.NewServer(
 			endpoints.StartEndpoint,
 			DecodeGRPCStartRequest,
 			EncodeGRPCStartResponse,
+			opts...,
 		),
 
 		remove: grpctransport.NewServer(
 			endpoints.RemoveEndpoint,
 			DecodeGRPCRemoveRequest,
 			EncodeGRPCRemoveResponse,
+			opts...,
 		),
 
 		change: grpctransport.NewServer(
 			endpoints.ChangeEndpoint,
 			DecodeGRPCChangeRequest,
 			EncodeGRPCChangeResponse,
+			opts...,
 		),
 
 		get: grpctransport.NewServer(
 			endpoints.GetEndpoint,
 			DecodeGRPCGetRequest,
 			EncodeGRPCGetResponse,
+			opts...,
 		),
 
 		list: grpctransport.NewServer(
 			endpoints.ListEndpoint,
 			DecodeGRPCListRequest,
 			EncodeGRPCListResponse,
+			opts...,
 		),
 
 		enable: grpctransport.NewServer(
 			endpoints.EnableEndpoint,
 			DecodeGRPCEnableRequest,
 			EncodeGRPCEnableResponse,
+			opts...,
 		),
 
 		disable: grpctransport.NewServer(
 			endpoints.DisableEndpoint,
 			DecodeGRPCDisableRequest,
 			EncodeGRPCDisableResponse,
+			opts...,
 		),
 	}
 	return req
 }
 
+// encodeError converts domain errors into gRPC status errors.
+func encodeError(err error) error {
+	switch err {
+	case auth.ErrUnauthorized, auth.ErrKeyExpired, auth.ErrKeyRevoked:
+		return status.Error(codes.Unauthenticated, err.Error())
+	case auth.ErrForbidden:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case endpoints.ErrRateLimited, da.ErrDiskFull:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return err
+}
+
 // DecodeGRPCAddRequest is a transport/grpc.DecodeRequestFunc that converts a
 // gRPC request to a user-domain request. Primarily useful in a server.
 // TODO: Do not forget to implement the decoder, you can find an example here :
@@ -96,7 +124,7 @@ func EncodeGRPCAddResponse(_ context.Context, grpcReply interface{}) (res interf
 func (s *grpcServer) Add(ctx oldcontext.Context, req *pb.AddRequest) (rep *pb.AddReply, err error) {
 	_, rp, err := s.add.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 	rep = rp.(*pb.AddReply)
 	return rep, err
@@ -123,7 +151,7 @@ func EncodeGRPCStartResponse(_ context.Context, grpcReply interface{}) (res inte
 func (s *grpcServer) Start(ctx oldcontext.Context, req *pb.StartRequest) (rep *pb.StartReply, err error) {
 	_, rp, err := s.start.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 	rep = rp.(*pb.StartReply)
 	return rep, err
@@ -150,7 +178,7 @@ func EncodeGRPCRemoveResponse(_ context.Context, grpcReply interface{}) (res int
 func (s *grpcServer) Remove(ctx oldcontext.Context, req *pb.RemoveRequest) (rep *pb.RemoveReply, err error) {
 	_, rp, err := s.remove.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 	rep = rp.(*pb.RemoveReply)
 	return rep, err
@@ -177,7 +205,7 @@ func EncodeGRPCChangeResponse(_ context.Context, grpcReply interface{}) (res int
 func (s *grpcServer) Change(ctx oldcontext.Context, req *pb.ChangeRequest) (rep *pb.ChangeReply, err error) {
 	_, rp, err := s.change.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 	rep = rp.(*pb.ChangeReply)
 	return rep, err
@@ -204,7 +232,7 @@ func EncodeGRPCGetResponse(_ context.Context, grpcReply interface{}) (res interf
 func (s *grpcServer) Get(ctx oldcontext.Context, req *pb.GetRequest) (rep *pb.GetReply, err error) {
 	_, rp, err := s.get.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 	rep = rp.(*pb.GetReply)
 	return rep, err
@@ -231,7 +259,7 @@ func EncodeGRPCListResponse(_ context.Context, grpcReply interface{}) (res inter
 func (s *grpcServer) List(ctx oldcontext.Context, req *pb.ListRequest) (rep *pb.ListReply, err error) {
 	_, rp, err := s.list.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 	rep = rp.(*pb.ListReply)
 	return rep, err
@@ -258,7 +286,7 @@ func EncodeGRPCEnableResponse(_ context.Context, grpcReply interface{}) (res int
 func (s *grpcServer) Enable(ctx oldcontext.Context, req *pb.EnableRequest) (rep *pb.EnableReply, err error) {
 	_, rp, err := s.enable.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 	rep = rp.(*pb.EnableReply)
 	return rep, err
@@ -285,7 +313,7 @@ func EncodeGRPCDisableResponse(_ context.Context, grpcReply interface{}) (res in
 func (s *grpcServer) Disable(ctx oldcontext.Context, req *pb.DisableRequest) (rep *pb.DisableReply, err error) {
 	_, rp, err := s.disable.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, encodeError(err)
 	}
 	rep = rp.(*pb.DisableReply)
 	return rep, err
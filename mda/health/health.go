@@ -0,0 +1,168 @@
+// Package health implements the liveness, readiness and diagnostics checks
+// served by the server and run by "mda doctor".
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/will7200/regconsul/client"
+)
+
+// Check is a single readiness probe. Optional checks are reported but do not
+// make the server unready.
+type Check struct {
+	Name     string
+	Optional bool
+	Run      func(ctx context.Context) (detail string, err error)
+}
+
+type Result struct {
+	Name     string
+	OK       bool
+	Optional bool   `json:",omitempty"`
+	Detail   string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+	Duration string
+}
+
+// Run executes every check and reports whether all required ones passed.
+func Run(ctx context.Context, checks []Check) (results []Result, ok bool) {
+	ok = true
+	for _, c := range checks {
+		begin := time.Now()
+		detail, err := c.Run(ctx)
+		r := Result{Name: c.Name, OK: err == nil, Optional: c.Optional, Detail: detail,
+			Duration: time.Since(begin).String()}
+		if err != nil {
+			r.Error = err.Error()
+			if !c.Optional {
+				ok = false
+			}
+		}
+		results = append(results, r)
+	}
+	return results, ok
+}
+
+// Database pings the database behind db.
+func Database(db *gorm.DB) Check {
+	return Check{Name: "database", Run: func(ctx context.Context) (string, error) {
+		if err := db.DB().Ping(); err != nil {
+			return "", err
+		}
+		return db.Dialect().GetName(), nil
+	}}
+}
+
+// WritableDir checks that dir is a directory the server may create files
+// in. It only looks, a probe must not change anything.
+func WritableDir(name, dir string) Check {
+	return Check{Name: name, Run: func(ctx context.Context) (string, error) {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return "", err
+		}
+		if !fi.IsDir() {
+			return "", fmt.Errorf("%s is not a directory", dir)
+		}
+		return dir, writable(dir, fi)
+	}}
+}
+
+// Func wraps fn as a check, fn returns a human readable detail.
+func Func(name string, optional bool, fn func(ctx context.Context) (string, error)) Check {
+	return Check{Name: name, Optional: optional, Run: fn}
+}
+
+// Scheduler looks the remote scheduler up in consul and dials it.
+func Scheduler(consulAddress, aclToken, service string) Check {
+	return Check{Name: "scheduler", Optional: true, Run: func(ctx context.Context) (string, error) {
+		cli, err := client.NewConsulClient(consulAddress, aclToken)
+		if err != nil {
+			return "", err
+		}
+		addresses, _, err := cli.Service(service, "")
+		if err != nil {
+			return "", err
+		}
+		if len(addresses) < 1 {
+			return "", fmt.Errorf("no instance of %s registered in consul", service)
+		}
+		addr := net.JoinHostPort(addresses[0].Service.Address, fmt.Sprint(addresses[0].Service.Port))
+		conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
+		if err != nil {
+			return "", err
+		}
+		conn.Close()
+		return addr, nil
+	}}
+}
+
+// LiveHandler answers as long as the process can serve http.
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// ReadyHandler runs checks on every request and answers 503 when a required
+// one fails.
+func ReadyHandler(checks []Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		results, ok := Run(ctx, checks)
+		code, status := http.StatusOK, "ok"
+		if !ok {
+			code, status = http.StatusServiceUnavailable, "unavailable"
+		}
+		writeJSON(w, code, struct {
+			Status string
+			Checks []Result
+		}{status, results})
+	})
+}
+
+// StatusHandler serves whatever status returns as JSON.
+func StatusHandler(status func() interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, status())
+	})
+}
+
+// Redact replaces every setting that looks like a credential.
+func Redact(settings map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			out[k] = Redact(t)
+			continue
+		}
+		lk := strings.ToLower(k)
+		for _, s := range []string{"secret", "token", "password", "connection", "key"} {
+			if strings.Contains(lk, s) {
+				v = "<redacted>"
+				break
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(v)
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package health
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// writable asks the kernel whether the server may write to dir.
+func writable(dir string, fi os.FileInfo) error {
+	if err := syscall.Access(dir, 0x2|0x1); err != nil {
+		return fmt.Errorf("%s is not writable: %s", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package health
+
+import (
+	"fmt"
+	"os"
+)
+
+// writable checks the read only attribute, which is all Windows keeps in the
+// mode of a directory.
+func writable(dir string, fi os.FileInfo) error {
+	if fi.Mode().Perm()&0200 == 0 {
+		return fmt.Errorf("%s is not writable", dir)
+	}
+	return nil
+}
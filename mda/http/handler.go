@@ -3,15 +3,30 @@ package http
 import (
 	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 	"github.com/will7200/mda/da"
+	"github.com/will7200/mda/mda/auth"
+	"github.com/will7200/mda/mda/callback"
 	"github.com/will7200/mda/mda/endpoints"
+	"github.com/will7200/mda/mda/feed"
 	"github.com/will7200/mda/mda/service"
 )
 
+var (
+	ErrInvalidDryRun = errors.New("Dry run is Invalid use true or false")
+	ErrInvalidForce  = errors.New("Force is Invalid use true or false")
+	ErrInvalidOPML   = errors.New("OPML document is Invalid")
+	ErrInvalidExport = errors.New("Export document is Invalid")
+)
+
 // NewHTTPHandler returns a handler that makes a set of endpoints available on
 // predefined paths.
 func NewHTTPHandler(endpoints endpoints.Endpoints) *mux.Router {
@@ -20,7 +35,25 @@ func NewHTTPHandler(endpoints endpoints.Endpoints) *mux.Router {
 	m := t.PathPrefix("/mda").Subrouter()
 	opts := []httptransport.ServerOption{
 		httptransport.ServerErrorEncoder(errorEncoder),
+		httptransport.ServerBefore(httptransport.PopulateRequestContext, auth.HTTPToContext, callback.HTTPToContext),
+	}
+	// Podcast apps can not set headers, feeds take the key from the query too.
+	feedOpts := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(errorEncoder),
+		httptransport.ServerBefore(httptransport.PopulateRequestContext, auth.HTTPQueryToContext),
 	}
+	t.Handle("/feeds/all.xml", httptransport.NewServer(
+		endpoints.FeedAllEndpoint,
+		DecodeFeedAllRequest,
+		EncodeFeedResponse,
+		feedOpts...,
+	)).Methods("GET")
+	t.Handle("/feeds/{id}.xml", httptransport.NewServer(
+		endpoints.FeedEndpoint,
+		DecodeFeedRequest,
+		EncodeFeedResponse,
+		feedOpts...,
+	)).Methods("GET")
 	m.Handle("/", httptransport.NewServer(
 		endpoints.AddEndpoint,
 		DecodeAddRequest,
@@ -45,6 +78,102 @@ func NewHTTPHandler(endpoints endpoints.Endpoints) *mux.Router {
 		EncodeChangeResponse,
 		opts...,
 	)).Methods("PUT")
+	m.Handle("/opml", httptransport.NewServer(
+		endpoints.ExportOPMLEndpoint,
+		DecodeExportOPMLRequest,
+		EncodeExportOPMLResponse,
+		opts...,
+	)).Methods("GET")
+	m.Handle("/opml", httptransport.NewServer(
+		endpoints.ImportOPMLEndpoint,
+		DecodeImportOPMLRequest,
+		EncodeImportOPMLResponse,
+		opts...,
+	)).Methods("POST")
+	m.Handle("/export", httptransport.NewServer(
+		endpoints.ExportEndpoint,
+		DecodeExportRequest,
+		EncodeExportResponse,
+		opts...,
+	)).Methods("GET")
+	m.Handle("/import", httptransport.NewServer(
+		endpoints.ImportEndpoint,
+		DecodeImportRequest,
+		EncodeImportResponse,
+		opts...,
+	)).Methods("POST")
+	m.Handle("/providers", httptransport.NewServer(
+		endpoints.ProvidersEndpoint,
+		DecodeProvidersRequest,
+		EncodeProvidersResponse,
+		opts...,
+	)).Methods("GET")
+	m.Handle("/items", httptransport.NewServer(
+		endpoints.SearchEndpoint,
+		DecodeSearchRequest,
+		EncodeSearchResponse,
+		opts...,
+	)).Methods("GET")
+	m.Handle("/sessions/{session}/items", httptransport.NewServer(
+		endpoints.ResultsEndpoint,
+		DecodeResultsRequest,
+		EncodeResultsResponse,
+		opts...,
+	)).Methods("GET")
+	m.Handle("/sessions/{session}/retry", httptransport.NewServer(
+		endpoints.RetryFailedEndpoint,
+		DecodeRetryFailedRequest,
+		EncodeRetryFailedResponse,
+		opts...,
+	)).Methods("POST")
+	m.Handle("/janitor", httptransport.NewServer(
+		endpoints.JanitorEndpoint,
+		DecodeJanitorRequest,
+		EncodeJanitorResponse,
+		opts...,
+	)).Methods("POST")
+	m.Handle("/{id}/clean", httptransport.NewServer(
+		endpoints.CleanEndpoint,
+		DecodeCleanRequest,
+		EncodeCleanResponse,
+		opts...,
+	)).Methods("POST")
+	m.Handle("/{id}/items", httptransport.NewServer(
+		endpoints.ItemsEndpoint,
+		DecodeItemsRequest,
+		EncodeItemsResponse,
+		opts...,
+	)).Methods("GET")
+	m.Handle("/{id}/archive", httptransport.NewServer(
+		endpoints.ArchiveEndpoint,
+		DecodeArchiveRequest,
+		EncodeArchiveResponse,
+		opts...,
+	)).Methods("GET")
+	m.Handle("/{id}/archive/reset", httptransport.NewServer(
+		endpoints.ResetArchiveEndpoint,
+		DecodeResetArchiveRequest,
+		EncodeResetArchiveResponse,
+		opts...,
+	)).Methods("POST")
+	m.Handle("/{id}/archive/prune", httptransport.NewServer(
+		endpoints.PruneArchiveEndpoint,
+		DecodePruneArchiveRequest,
+		EncodePruneArchiveResponse,
+		opts...,
+	)).Methods("POST")
+	m.Handle("/{id}/rewind", httptransport.NewServer(
+		endpoints.RewindEndpoint,
+		DecodeRewindRequest,
+		EncodeRewindResponse,
+		opts...,
+	)).Methods("POST")
+	m.Handle("/{id}/history", httptransport.NewServer(
+		endpoints.HistoryEndpoint,
+		DecodeHistoryRequest,
+		EncodeHistoryResponse,
+		opts...,
+	)).Methods("GET")
 	m.Handle("/{id}", httptransport.NewServer(
 		endpoints.GetEndpoint,
 		DecodeGetRequest,
@@ -80,10 +209,29 @@ func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	msg := err.Error()
 	switch err {
-	case service.ErrDaDNE:
+	case service.ErrDaDNE, service.ErrSessionDNE:
 		w.WriteHeader(http.StatusNotFound)
-	case service.ErrInvalidLocation:
+	case service.ErrInvalidLocation, service.ErrInvalidDate, service.ErrUntrustedHost, service.ErrInvalidClass, da.ErrUnknownStep,
+		da.ErrPathEscapesHome, da.ErrUnknownStorage, da.ErrUnknownBackend, ErrInvalidDryRun, ErrInvalidOPML,
+		ErrInvalidExport, service.ErrEmptyOPML, service.ErrInvalidFormat, service.ErrInvalidMode, service.ErrExportVersion,
+		da.ErrInvalidName, da.ErrReservedName, da.ErrInvalidID, ErrInvalidForce, service.ErrReplaceAll:
 		w.WriteHeader(http.StatusBadRequest)
+	case auth.ErrUnauthorized, auth.ErrKeyExpired, auth.ErrKeyRevoked:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+	case auth.ErrForbidden:
+		w.WriteHeader(http.StatusForbidden)
+	case callback.ErrTokenInvalid, callback.ErrTokenExpired, callback.ErrTokenReplayed:
+		w.WriteHeader(http.StatusUnauthorized)
+	case service.ErrNoFailedItems, da.ErrAlreadyInQueue, da.ErrCleaning, da.ErrNameTaken:
+		w.WriteHeader(http.StatusConflict)
+	case da.ErrDiskFull:
+		w.WriteHeader(http.StatusInsufficientStorage)
+	case service.ErrNoSecret:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case endpoints.ErrRateLimited:
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
 	default:
 		w.WriteHeader(http.StatusInternalServerError)
 	}
@@ -185,9 +333,7 @@ func EncodeGetResponse(_ context.Context, w http.ResponseWriter, response interf
 // DecodeListRequest is a transport/http.DecodeRequestFunc that decodes a
 // JSON-encoded request from the HTTP request body. Primarily useful in a server.
 func DecodeListRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
-	//req = endpoints.ListRequest{}
-	//err = json.NewDecoder(r.Body).Decode(&r)
-	return nil, nil
+	return endpoints.ListRequest{ErrorClass: r.URL.Query().Get("class")}, nil
 }
 
 // EncodeListResponse is a transport/http.EncodeResponseFunc that encodes
@@ -235,3 +381,405 @@ func EncodeDisableResponse(_ context.Context, w http.ResponseWriter, response in
 	err = e.Encode(response)
 	return err
 }
+
+// DecodeProvidersRequest is a transport/http.DecodeRequestFunc that decodes a
+// JSON-encoded request from the HTTP request body. Primarily useful in a server.
+func DecodeProvidersRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.ProvidersRequest{}, nil
+}
+
+// EncodeProvidersResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeProvidersResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodeItemsRequest is a transport/http.DecodeRequestFunc that decodes a
+// JSON-encoded request from the HTTP request body. Primarily useful in a server.
+func DecodeItemsRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.ItemsRequest{Id: mux.Vars(r)["id"]}, nil
+}
+
+// EncodeItemsResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeItemsResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodeSearchRequest is a transport/http.DecodeRequestFunc that decodes a
+// JSON-encoded request from the HTTP request body. Primarily useful in a server.
+func DecodeSearchRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.SearchRequest{Query: r.URL.Query().Get("q")}, nil
+}
+
+// EncodeSearchResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeSearchResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodeArchiveRequest is a transport/http.DecodeRequestFunc that decodes a
+// JSON-encoded request from the HTTP request body. Primarily useful in a server.
+func DecodeArchiveRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.ArchiveRequest{Id: mux.Vars(r)["id"]}, nil
+}
+
+// EncodeArchiveResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeArchiveResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodeResetArchiveRequest is a transport/http.DecodeRequestFunc that decodes a
+// JSON-encoded request from the HTTP request body. Primarily useful in a server.
+func DecodeResetArchiveRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.ResetArchiveRequest{Id: mux.Vars(r)["id"]}, nil
+}
+
+// EncodeResetArchiveResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeResetArchiveResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodePruneArchiveRequest is a transport/http.DecodeRequestFunc that decodes a
+// JSON-encoded request from the HTTP request body. Primarily useful in a server.
+func DecodePruneArchiveRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.PruneArchiveRequest{Id: mux.Vars(r)["id"]}, nil
+}
+
+// EncodePruneArchiveResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodePruneArchiveResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// rewindFormats are the layouts the to parameter of rewind accepts.
+var rewindFormats = []string{"2006-01-02", "20060102", time.RFC3339}
+
+// DecodeRewindRequest is a transport/http.DecodeRequestFunc that decodes the
+// id from the path and the date from the to query parameter.
+func DecodeRewindRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	to := r.URL.Query().Get("to")
+	for _, layout := range rewindFormats {
+		if t, err := time.Parse(layout, to); err == nil {
+			return endpoints.RewindRequest{Id: mux.Vars(r)["id"], To: t}, nil
+		}
+	}
+	return nil, service.ErrInvalidDate
+}
+
+// EncodeRewindResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeRewindResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodeHistoryRequest is a transport/http.DecodeRequestFunc that decodes the
+// id from the path and the error class filter from the class query parameter.
+func DecodeHistoryRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.HistoryRequest{Id: mux.Vars(r)["id"], ErrorClass: r.URL.Query().Get("class")}, nil
+}
+
+// EncodeHistoryResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeHistoryResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodeResultsRequest is a transport/http.DecodeRequestFunc that decodes the
+// session from the path. Primarily useful in a server.
+func DecodeResultsRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.ResultsRequest{Session: mux.Vars(r)["session"]}, nil
+}
+
+// EncodeResultsResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeResultsResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodeRetryFailedRequest is a transport/http.DecodeRequestFunc that decodes the
+// session from the path. Primarily useful in a server.
+func DecodeRetryFailedRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.RetryFailedRequest{Session: mux.Vars(r)["session"]}, nil
+}
+
+// EncodeRetryFailedResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeRetryFailedResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodeCleanRequest is a transport/http.DecodeRequestFunc that decodes the
+// DA id and the dry_run query parameter. Primarily useful in a server.
+func DecodeCleanRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	dryRun, err := dryRunParam(r)
+	if err != nil {
+		return nil, err
+	}
+	return endpoints.CleanRequest{Id: mux.Vars(r)["id"], DryRun: dryRun}, nil
+}
+
+// EncodeCleanResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeCleanResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodeJanitorRequest is a transport/http.DecodeRequestFunc that decodes the
+// dry_run query parameter. Primarily useful in a server.
+func DecodeJanitorRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	dryRun, err := dryRunParam(r)
+	if err != nil {
+		return nil, err
+	}
+	return endpoints.JanitorRequest{DryRun: dryRun}, nil
+}
+
+// EncodeJanitorResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeJanitorResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodeFeedRequest is a transport/http.DecodeRequestFunc that decodes the
+// DA id and the links of the feed from the request. Primarily useful in a
+// server.
+func DecodeFeedRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.FeedRequest{Id: mux.Vars(r)["id"], Links: requestLinks(r)}, nil
+}
+
+// EncodeFeedResponse is a transport/http.EncodeResponseFunc that encodes
+// the feed of a FeedResponse or FeedAllResponse as XML to the response
+// writer. Primarily useful in a server.
+func EncodeFeedResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	var rss *feed.RSS
+	switch r := response.(type) {
+	case endpoints.FeedResponse:
+		rss = r.Result
+	case endpoints.FeedAllResponse:
+		rss = r.Result
+	}
+	return encodeXML(w, "application/rss+xml; charset=utf-8", rss)
+}
+
+// DecodeFeedAllRequest is a transport/http.DecodeRequestFunc that decodes the
+// links of the feed from the request. Primarily useful in a server.
+func DecodeFeedAllRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.FeedAllRequest{Links: requestLinks(r)}, nil
+}
+
+// DecodeExportOPMLRequest is a transport/http.DecodeRequestFunc that decodes
+// the links of the feeds from the request. Primarily useful in a server.
+func DecodeExportOPMLRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	return endpoints.ExportOPMLRequest{Links: requestLinks(r)}, nil
+}
+
+// EncodeExportOPMLResponse is a transport/http.EncodeResponseFunc that
+// encodes the OPML document as XML to the response writer. Primarily useful
+// in a server.
+func EncodeExportOPMLResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Disposition", `attachment; filename="mda.opml"`)
+	return encodeXML(w, "text/x-opml; charset=utf-8", response.(endpoints.ExportOPMLResponse).Result)
+}
+
+// DecodeImportOPMLRequest is a transport/http.DecodeRequestFunc that decodes
+// an OPML document from the body. The frequency, location and startdate
+// query parameters apply to outlines that do not set their own, startdate
+// defaults to today. Primarily useful in a server.
+func DecodeImportOPMLRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	q := r.URL.Query()
+	start := time.Now().Truncate(24 * time.Hour)
+	if s := q.Get("startdate"); s != "" {
+		parsed := false
+		for _, layout := range rewindFormats {
+			if t, err := time.Parse(layout, s); err == nil {
+				start, parsed = t, true
+				break
+			}
+		}
+		if !parsed {
+			return nil, service.ErrInvalidDate
+		}
+	}
+	doc := feed.OPML{}
+	if err := xml.NewDecoder(r.Body).Decode(&doc); err != nil {
+		return nil, ErrInvalidOPML
+	}
+	defaults := da.DA{Frequency: q.Get("frequency"), Location: q.Get("location"), Startdate: &start}
+	return endpoints.ImportOPMLRequest{Doc: doc, Defaults: defaults}, nil
+}
+
+// EncodeImportOPMLResponse is a transport/http.EncodeResponseFunc that
+// encodes the response as JSON to the response writer. Primarily useful in
+// a server.
+func EncodeImportOPMLResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// DecodeExportRequest is a transport/http.DecodeRequestFunc that decodes the
+// format from the format query parameter, or else the Accept header.
+// Primarily useful in a server.
+func DecodeExportRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = service.FormatOf(r.Header.Get("Accept"))
+	}
+	if format != "json" && format != "yaml" {
+		return nil, service.ErrInvalidFormat
+	}
+	return endpoints.ExportRequest{Format: format}, nil
+}
+
+// EncodeExportResponse is a transport/http.EncodeResponseFunc that encodes
+// the export as JSON or YAML to the response writer. Primarily useful in a
+// server.
+func EncodeExportResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	resp := response.(endpoints.ExportResponse)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if resp.Format == "yaml" {
+		w.Header().Set("Content-Type", "application/x-yaml; charset=utf-8")
+	}
+	return resp.Result.Encode(w, resp.Format)
+}
+
+// DecodeImportRequest is a transport/http.DecodeRequestFunc that decodes an
+// export from the body, JSON or YAML as the format query parameter or the
+// Content-Type say, and the mode, dry_run and force query parameters. Primarily
+// useful in a server.
+func DecodeImportRequest(_ context.Context, r *http.Request) (req interface{}, err error) {
+	q := r.URL.Query()
+	mode, err := service.ParseImportMode(q.Get("mode"))
+	if err != nil {
+		return nil, err
+	}
+	dryRun, err := dryRunParam(r)
+	if err != nil {
+		return nil, err
+	}
+	force, err := forceParam(r)
+	if err != nil {
+		return nil, err
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = service.FormatOf(r.Header.Get("Content-Type"))
+	}
+	doc, err := service.DecodeExport(r.Body, format)
+	if err == service.ErrInvalidFormat {
+		return nil, err
+	}
+	if err != nil {
+		return nil, ErrInvalidExport
+	}
+	return endpoints.ImportRequest{Doc: doc, Mode: mode, DryRun: dryRun, Force: force}, nil
+}
+
+// EncodeImportResponse is a transport/http.EncodeResponseFunc that encodes
+// the response as JSON to the response writer. Primarily useful in a server.
+func EncodeImportResponse(_ context.Context, w http.ResponseWriter, response interface{}) (err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	err = e.Encode(response)
+	return err
+}
+
+// encodeXML writes v as an indented XML document of type contentType.
+func encodeXML(w http.ResponseWriter, contentType string, v interface{}) error {
+	w.Header().Set("Content-Type", contentType)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "\t")
+	return e.Encode(v)
+}
+
+// requestLinks are the links of a feed served on r, on the scheme and host
+// the client used and with its key when it passed one in the query.
+func requestLinks(r *http.Request) feed.Links {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); p == "http" || p == "https" {
+		scheme = p
+	}
+	return feed.Links{Base: scheme + "://" + r.Host, Key: r.URL.Query().Get("key")}
+}
+
+// dryRunParam reads the optional dry_run query parameter.
+func dryRunParam(r *http.Request) (bool, error) {
+	return boolParam(r, "dry_run", ErrInvalidDryRun)
+}
+
+// forceParam reads the optional force query parameter.
+func forceParam(r *http.Request) (bool, error) {
+	return boolParam(r, "force", ErrInvalidForce)
+}
+
+func boolParam(r *http.Request, name string, invalid error) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, invalid
+	}
+	return b, nil
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/will7200/mda/da"
+	"github.com/will7200/mda/mda/auth"
+	"github.com/will7200/mda/mda/endpoints"
+	"github.com/will7200/mda/mda/service"
+)
+
+func TestErrorEncoder(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{service.ErrDaDNE, http.StatusNotFound},
+		{service.ErrSessionDNE, http.StatusNotFound},
+		{da.ErrInvalidID, http.StatusBadRequest},
+		{auth.ErrUnauthorized, http.StatusUnauthorized},
+		{auth.ErrForbidden, http.StatusForbidden},
+		{da.ErrNameTaken, http.StatusConflict},
+		{da.ErrDiskFull, http.StatusInsufficientStorage},
+		{endpoints.ErrRateLimited, http.StatusTooManyRequests},
+		{errors.New("database is gone"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		errorEncoder(context.Background(), tt.err, w)
+		if w.Code != tt.want {
+			t.Errorf("errorEncoder(%q) status = %d, want %d", tt.err, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+var ErrNoAddress = errors.New("Unable to find an address to advertise, set interface.advertise_address or interface.public_url")
+
+// CallbackURL returns the url the remote scheduler uses to start id.
+// interface.public_url wins over interface.advertise_address, which wins over
+// whatever address the local interfaces have. An advertise address that
+// listens on all interfaces, like 0.0.0.0:4004, only gives the port.
+func CallbackURL(id string) (string, error) {
+	if u := viper.GetString("interface.public_url"); u != "" {
+		return fmt.Sprintf("%s/mda/start/%s", strings.TrimRight(u, "/"), id), nil
+	}
+	host, port := viper.GetString("interface.advertise_address"), strconv.Itoa(viper.GetInt("interface.port"))
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	host = strings.Trim(host, "[]")
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		ip, err := discoverIP()
+		if err != nil {
+			return "", err
+		}
+		host = ip.String()
+	}
+	return fmt.Sprintf("http://%s/mda/start/%s", net.JoinHostPort(host, port), id), nil
+}
+
+// discoverIP is DiscoverIP, tests replace it.
+var discoverIP = DiscoverIP
+
+// DiscoverIP picks an address from the interfaces that are up without
+// needing a route to the internet, as pickIP does.
+func DiscoverIP() (net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+	return pickIP(ips)
+}
+
+// pickIP prefers global IPv4 addresses, then global IPv6, then link-local
+// ones. Loopback addresses are never picked.
+func pickIP(ips []net.IP) (net.IP, error) {
+	var v6, linkLocal net.IP
+	for _, ip := range ips {
+		switch {
+		case ip.IsLoopback() || ip.IsUnspecified():
+		case ip.IsLinkLocalUnicast():
+			if linkLocal == nil {
+				linkLocal = ip
+			}
+		case ip.To4() != nil:
+			return ip, nil
+		case v6 == nil:
+			v6 = ip
+		}
+	}
+	if v6 != nil {
+		return v6, nil
+	}
+	if linkLocal != nil {
+		return linkLocal, nil
+	}
+	return nil, ErrNoAddress
+}
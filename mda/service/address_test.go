@@ -0,0 +1,89 @@
+package service
+
+import (
+	"net"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCallbackURL(t *testing.T) {
+	defer func(f func() (net.IP, error)) { discoverIP = f }(discoverIP)
+	tests := []struct {
+		name       string
+		public     string
+		advertise  string
+		discovered string
+		want       string
+	}{
+		{"public url", "https://mda.example.com/", "10.0.0.5", "", "https://mda.example.com/mda/start/id"},
+		{"host", "", "mda.example.com", "", "http://mda.example.com:4004/mda/start/id"},
+		{"host and port", "", "mda.example.com:9000", "", "http://mda.example.com:9000/mda/start/id"},
+		{"ipv6", "", "2001:db8::1", "", "http://[2001:db8::1]:4004/mda/start/id"},
+		{"bracketed ipv6", "", "[2001:db8::1]", "", "http://[2001:db8::1]:4004/mda/start/id"},
+		{"ipv6 and port", "", "[2001:db8::1]:9000", "", "http://[2001:db8::1]:9000/mda/start/id"},
+		{"discovered", "", "", "192.0.2.7", "http://192.0.2.7:4004/mda/start/id"},
+		{"discovered ipv6", "", "", "2001:db8::7", "http://[2001:db8::7]:4004/mda/start/id"},
+		{"listen all", "", "0.0.0.0", "192.0.2.7", "http://192.0.2.7:4004/mda/start/id"},
+		{"listen all port", "", "0.0.0.0:9000", "192.0.2.7", "http://192.0.2.7:9000/mda/start/id"},
+		{"listen all ipv6", "", "[::]:9000", "2001:db8::7", "http://[2001:db8::7]:9000/mda/start/id"},
+		{"port only", "", ":9000", "192.0.2.7", "http://192.0.2.7:9000/mda/start/id"},
+	}
+	for _, tt := range tests {
+		viper.Set("interface.public_url", tt.public)
+		viper.Set("interface.advertise_address", tt.advertise)
+		viper.Set("interface.port", 4004)
+		discovered := false
+		discoverIP = func() (net.IP, error) {
+			discovered = true
+			return net.ParseIP(tt.discovered), nil
+		}
+		got, err := CallbackURL("id")
+		if err != nil {
+			t.Errorf("%s: CallbackURL() error = %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: CallbackURL() = %q, want %q", tt.name, got, tt.want)
+		}
+		if discovered != (tt.discovered != "") {
+			t.Errorf("%s: CallbackURL() discovered an address = %v", tt.name, discovered)
+		}
+	}
+	viper.Set("interface.public_url", "")
+	viper.Set("interface.advertise_address", "")
+}
+
+func TestPickIP(t *testing.T) {
+	ips := func(addrs ...string) []net.IP {
+		out := make([]net.IP, len(addrs))
+		for i, a := range addrs {
+			out[i] = net.ParseIP(a)
+		}
+		return out
+	}
+	tests := []struct {
+		name string
+		ips  []net.IP
+		want string
+	}{
+		{"ipv4 first", ips("fe80::1", "2001:db8::1", "192.0.2.1"), "192.0.2.1"},
+		{"ipv6 over link local", ips("fe80::1", "169.254.0.1", "2001:db8::1"), "2001:db8::1"},
+		{"link local", ips("127.0.0.1", "fe80::1"), "fe80::1"},
+		{"no loopback", ips("127.0.0.1", "::1"), ""},
+		{"no unspecified", ips("0.0.0.0", "::"), ""},
+		{"none", nil, ""},
+	}
+	for _, tt := range tests {
+		got, err := pickIP(tt.ips)
+		if tt.want == "" {
+			if err != ErrNoAddress {
+				t.Errorf("%s: pickIP() = %v, %v, want %v", tt.name, got, err, ErrNoAddress)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("%s: pickIP() = %v, %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
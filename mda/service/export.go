@@ -0,0 +1,268 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/will7200/mda/da"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	ErrInvalidFormat = errors.New("Format is Invalid use json or yaml")
+	ErrInvalidMode   = errors.New("Import mode is Invalid use merge, replace or skip")
+)
+
+// ExportVersion is the version of the Export document this server writes.
+const ExportVersion = 1
+
+// Export is the document export writes and import reads, as JSON or YAML.
+type Export struct {
+	Version       int            `json:"version" yaml:"version"`
+	Subscriptions []Subscription `json:"subscriptions" yaml:"subscriptions"`
+}
+
+// Subscription is a DA as it is exported, watermark included.
+type Subscription struct {
+	ID          string            `json:"id,omitempty" yaml:"id,omitempty"`
+	Name        string            `json:"name,omitempty" yaml:"name,omitempty"`
+	URL         string            `json:"url" yaml:"url"`
+	Location    string            `json:"location,omitempty" yaml:"location,omitempty"`
+	Frequency   string            `json:"frequency,omitempty" yaml:"frequency,omitempty"`
+	Owner       string            `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Enabled     bool              `json:"enabled" yaml:"enabled"`
+	Parameters  map[string]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Backend     string            `json:"backend,omitempty" yaml:"backend,omitempty"`
+	Storage     string            `json:"storage,omitempty" yaml:"storage,omitempty"`
+	StopAfter   int               `json:"stop_after,omitempty" yaml:"stop_after,omitempty"`
+	Startdate   *time.Time        `json:"startdate,omitempty" yaml:"startdate,omitempty"`
+	Currentdate *time.Time        `json:"currentdate,omitempty" yaml:"currentdate,omitempty"`
+	Retry       *da.RetryPolicy   `json:"retry,omitempty" yaml:"retry,omitempty"`
+	Steps       da.Pipeline       `json:"steps,omitempty" yaml:"steps,omitempty"`
+	Layout      *da.Layout        `json:"layout,omitempty" yaml:"layout,omitempty"`
+	Retention   *da.Retention     `json:"retention,omitempty" yaml:"retention,omitempty"`
+}
+
+func subscriptionOf(d da.DA) Subscription {
+	return Subscription{ID: d.ID, Name: d.Name, URL: d.URL, Location: d.Location, Frequency: d.Frequency,
+		Owner: d.Owner, Enabled: d.Enabled, Parameters: d.Parameters, Backend: d.Backend,
+		Storage: d.Storage, StopAfter: d.StopAfter, Startdate: d.Startdate, Currentdate: d.Currentdate,
+		Retry: d.Retry, Steps: d.Steps, Layout: d.Layout, Retention: d.Retention}
+}
+
+// DA is the record s describes.
+func (s Subscription) DA() da.DA {
+	return da.DA{ID: s.ID, Name: s.Name, URL: s.URL, Location: s.Location, Frequency: s.Frequency,
+		Owner: s.Owner, Enabled: s.Enabled, Parameters: s.Parameters, Backend: s.Backend,
+		Storage: s.Storage, StopAfter: s.StopAfter, Startdate: s.Startdate, Currentdate: s.Currentdate,
+		Retry: s.Retry, Steps: s.Steps, Layout: s.Layout, Retention: s.Retention}
+}
+
+// UnmarshalJSON defaults Enabled to true, a DA written by hand is meant to
+// run. Unknown fields are refused like YAML does.
+func (s *Subscription) UnmarshalJSON(b []byte) error {
+	type plain Subscription
+	p := plain{Enabled: true}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return err
+	}
+	*s = Subscription(p)
+	return nil
+}
+
+// UnmarshalYAML defaults Enabled to true like UnmarshalJSON.
+func (s *Subscription) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Subscription
+	p := plain{Enabled: true}
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*s = Subscription(p)
+	return nil
+}
+
+// over returns stored with the fields s sets replaced. Enabled and
+// StopAfter are always taken from s, other empty fields keep their stored
+// value.
+func (s Subscription) over(stored da.DA) da.DA {
+	d := stored
+	if s.Name != "" {
+		d.Name = s.Name
+	}
+	if s.URL != "" {
+		d.URL = s.URL
+	}
+	if s.Location != "" {
+		d.Location = s.Location
+	}
+	if s.Frequency != "" {
+		d.Frequency = s.Frequency
+	}
+	if s.Owner != "" {
+		d.Owner = s.Owner
+	}
+	d.Enabled = s.Enabled
+	if s.Parameters != nil {
+		d.Parameters = s.Parameters
+	}
+	if s.Backend != "" {
+		d.Backend = s.Backend
+	}
+	if s.Storage != "" {
+		d.Storage = s.Storage
+	}
+	d.StopAfter = s.StopAfter
+	if s.Startdate != nil {
+		d.Startdate = s.Startdate
+	}
+	if s.Currentdate != nil {
+		d.Currentdate = s.Currentdate
+	}
+	if s.Retry != nil {
+		d.Retry = s.Retry
+	}
+	if s.Steps != nil {
+		d.Steps = s.Steps
+	}
+	if s.Layout != nil {
+		d.Layout = s.Layout
+	}
+	if s.Retention != nil {
+		d.Retention = s.Retention
+	}
+	return d
+}
+
+// changedFields names the fields that differ between a and b.
+func changedFields(a, b Subscription) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var fields []string
+	for i := 0; i < va.NumField(); i++ {
+		x, y := va.Field(i).Interface(), vb.Field(i).Interface()
+		if tx, ok := x.(*time.Time); ok {
+			ty := y.(*time.Time)
+			if (tx == nil) != (ty == nil) || tx != nil && !tx.Equal(*ty) {
+				fields = append(fields, va.Type().Field(i).Name)
+			}
+			continue
+		}
+		jx, _ := json.Marshal(x)
+		jy, _ := json.Marshal(y)
+		if !bytes.Equal(jx, jy) {
+			fields = append(fields, va.Type().Field(i).Name)
+		}
+	}
+	return fields
+}
+
+// ImportMode says what import does with DAs that already exist.
+type ImportMode string
+
+const (
+	// ImportMerge updates existing DAs and creates the others.
+	ImportMerge ImportMode = "merge"
+	// ImportReplace is merge that also removes the DAs the document does
+	// not list.
+	ImportReplace ImportMode = "replace"
+	// ImportSkip only creates the DAs that do not exist yet.
+	ImportSkip ImportMode = "skip"
+)
+
+// ParseImportMode checks that s names an ImportMode, empty is merge.
+func ParseImportMode(s string) (ImportMode, error) {
+	switch m := ImportMode(strings.ToLower(s)); m {
+	case "":
+		return ImportMerge, nil
+	case ImportMerge, ImportReplace, ImportSkip:
+		return m, nil
+	}
+	return "", ErrInvalidMode
+}
+
+// The actions of an ImportChange.
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionRemove    = "remove"
+	ActionSkip      = "skip"
+	ActionUnchanged = "unchanged"
+	ActionInvalid   = "invalid"
+	// ActionOrphan is a DA the subscriptions config no longer lists, kept
+	// because pruning is off.
+	ActionOrphan = "orphan"
+)
+
+// ImportChange is what import did, or would do on a dry run, to one DA.
+// Fields are the ones an update changes.
+type ImportChange struct {
+	Action string
+	ID     string   `json:",omitempty"`
+	Name   string   `json:",omitempty"`
+	URL    string   `json:",omitempty"`
+	Fields []string `json:",omitempty"`
+	Error  string   `json:",omitempty"`
+}
+
+// ImportReport lists the changes of an import.
+type ImportReport struct {
+	Mode    ImportMode
+	DryRun  bool
+	Changes []ImportChange
+}
+
+// FormatOf guesses the format of a file name or content type, json unless
+// it mentions yaml or yml.
+func FormatOf(name string) string {
+	name = strings.ToLower(name)
+	if strings.Contains(name, "yaml") || filepath.Ext(name) == ".yml" {
+		return "yaml"
+	}
+	return "json"
+}
+
+// Encode writes e as format, json or yaml.
+func (e *Export) Encode(w io.Writer, format string) error {
+	switch format {
+	case "json", "":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(e)
+	case "yaml":
+		b, err := yaml.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	return ErrInvalidFormat
+}
+
+// DecodeExport reads an Export written as format.
+func DecodeExport(r io.Reader, format string) (Export, error) {
+	e := Export{}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return e, err
+	}
+	switch format {
+	case "json", "":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&e)
+	case "yaml":
+		err = yaml.UnmarshalStrict(b, &e)
+	default:
+		err = ErrInvalidFormat
+	}
+	return e, err
+}
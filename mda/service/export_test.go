@@ -0,0 +1,94 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/will7200/mda/da"
+)
+
+func TestParseImportMode(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    ImportMode
+		wantErr bool
+	}{
+		{"", ImportMerge, false},
+		{"merge", ImportMerge, false},
+		{"Replace", ImportReplace, false},
+		{"skip", ImportSkip, false},
+		{"sync", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseImportMode(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseImportMode(%q) = %q, %v, want %q, wantErr %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDecodeExport(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		enabled bool
+		wantErr bool
+	}{
+		{"enabled by default", `{"version":1,"subscriptions":[{"url":"https://example.com/a"}]}`, true, false},
+		{"disabled", `{"version":1,"subscriptions":[{"url":"https://example.com/a","enabled":false}]}`, false, false},
+		{"unknown field", `{"version":1,"subscriptions":[{"url":"https://example.com/a","paramters":{}}]}`, false, true},
+		{"unknown top level field", `{"version":1,"subs":[]}`, false, true},
+		{"malformed", `{"version":`, false, true},
+	}
+	for _, tt := range tests {
+		e, err := DecodeExport(strings.NewReader(tt.doc), "json")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: DecodeExport() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (len(e.Subscriptions) != 1 || e.Subscriptions[0].Enabled != tt.enabled) {
+			t.Errorf("%s: DecodeExport() = %+v, want one subscription with Enabled %v", tt.name, e, tt.enabled)
+		}
+	}
+	if _, err := DecodeExport(strings.NewReader("{}"), "xml"); err != ErrInvalidFormat {
+		t.Errorf("DecodeExport() of xml error = %v, want %v", err, ErrInvalidFormat)
+	}
+}
+
+func TestSubscriptionOver(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	stored := da.DA{ID: "id", Name: "old", URL: "https://example.com/old", Frequency: "@daily", Owner: "ann",
+		Enabled: true, StopAfter: 5, Startdate: &start, Parameters: da.Metadata{"-f": "mp4"}}
+	got := Subscription{Name: "new", Enabled: false}.over(stored)
+	want := stored
+	want.Name, want.Enabled, want.StopAfter = "new", false, 0
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("over() = %+v, want %+v", got, want)
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	a := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := a.In(time.FixedZone("x", 3600))
+	c := a.AddDate(0, 0, 1)
+	base := Subscription{URL: "https://example.com/a", Enabled: true, Startdate: &a}
+	tests := []struct {
+		name string
+		sub  Subscription
+		want []string
+	}{
+		{"same", base, nil},
+		{"same instant in another zone", Subscription{URL: base.URL, Enabled: true, Startdate: &b}, nil},
+		{"later start", Subscription{URL: base.URL, Enabled: true, Startdate: &c}, []string{"Startdate"}},
+		{"no start", Subscription{URL: base.URL, Enabled: true}, []string{"Startdate"}},
+		{"url and enabled", Subscription{URL: "https://example.com/b", Startdate: &a}, []string{"URL", "Enabled"}},
+		{"parameters", Subscription{URL: base.URL, Enabled: true, Startdate: &a, Parameters: map[string]string{"-x": ""}}, []string{"Parameters"}},
+	}
+	for _, tt := range tests {
+		if got := changedFields(base, tt.sub); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: changedFields() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -17,6 +19,9 @@ import (
 	"github.com/jinzhu/gorm"
 	log "github.com/sirupsen/logrus"
 	"github.com/will7200/mda/da"
+	"github.com/will7200/mda/mda/auth"
+	"github.com/will7200/mda/mda/feed"
+	"github.com/will7200/mda/tracing"
 	"github.com/will7200/mjs/apischeduler"
 	"github.com/will7200/mjs/apischeduler/grpc/pb"
 )
@@ -41,53 +46,216 @@ type MdaService interface {
 	Get(ctx context.Context, id string) (result *da.DA, err error)
 	//METHODS: GET
 	//PATH: /
-	List(ctx context.Context) (results *[]da.DA, err error)
+	List(ctx context.Context, class string) (results *[]da.DA, err error)
 	//METHODS: POST
 	//PATH: /enable
 	Enable(ctx context.Context, id string) (message string, err error)
 	//METHODS: POST
 	//PATH: /disable
 	Disable(ctx context.Context, id string) (message string, err error)
+	//METHODS: GET
+	//PATH: /providers
+	Providers(ctx context.Context) (results []da.Provider, err error)
+	//METHODS: GET
+	//PATH: /{id}/items
+	Items(ctx context.Context, id string) (results []da.MediaItem, err error)
+	//METHODS: GET
+	//PATH: /items
+	Search(ctx context.Context, query string) (results []da.MediaItem, err error)
+	//METHODS: GET
+	//PATH: /{id}/archive
+	Archive(ctx context.Context, id string) (results []da.ArchiveEntry, err error)
+	//METHODS: POST
+	//PATH: /{id}/archive/reset
+	ResetArchive(ctx context.Context, id string) (message string, err error)
+	//METHODS: POST
+	//PATH: /{id}/archive/prune
+	PruneArchive(ctx context.Context, id string) (message string, err error)
+	//METHODS: POST
+	//PATH: /{id}/rewind
+	Rewind(ctx context.Context, id string, to time.Time) (message string, err error)
+	//METHODS: GET
+	//PATH: /{id}/history
+	History(ctx context.Context, id string, class string) (results []da.Stats, err error)
+	//METHODS: GET
+	//PATH: /sessions/{session}/items
+	Results(ctx context.Context, session string) (results []da.ItemResult, err error)
+	//METHODS: POST
+	//PATH: /sessions/{session}/retry
+	RetryFailed(ctx context.Context, session string) (message string, err error)
+	//METHODS: POST
+	//PATH: /{id}/clean
+	Clean(ctx context.Context, id string, dryRun bool) (report *da.RetentionReport, err error)
+	//METHODS: POST
+	//PATH: /janitor
+	Janitor(ctx context.Context, dryRun bool) (reports []*da.RetentionReport, err error)
+	//METHODS: GET
+	//PATH: /feeds/{id}.xml
+	Feed(ctx context.Context, id string, links feed.Links) (result *feed.RSS, err error)
+	//METHODS: GET
+	//PATH: /feeds/all.xml
+	FeedAll(ctx context.Context, links feed.Links) (result *feed.RSS, err error)
+	//METHODS: GET
+	//PATH: /opml
+	ExportOPML(ctx context.Context, links feed.Links) (result *feed.OPML, err error)
+	//METHODS: POST
+	//PATH: /opml
+	ImportOPML(ctx context.Context, doc feed.OPML, defaults da.DA) (results []ImportResult, err error)
+	//METHODS: GET
+	//PATH: /export
+	Export(ctx context.Context) (result *Export, err error)
+	//METHODS: POST
+	//PATH: /import
+	Import(ctx context.Context, doc Export, mode ImportMode, dryRun, force bool) (result *ImportReport, err error)
+	// Reconcile is run by the server with the subscriptions config, it is
+	// not served.
+	Reconcile(ctx context.Context, subs []Subscription, prune bool, maxPrune float64) (result *ImportReport, err error)
 	AddToSchedular(ctx context.Context, id string) error
 }
 type stubMdaService struct {
-	db *gorm.DB
-	da da.Downloader
+	db        *gorm.DB
+	da        da.Downloader
+	providers *da.Registry
+	layout    da.Layout
 }
 
 var (
 	ErrInvalidLocation = errors.New("Location is Invalid view supported Providers")
 	ErrDaDNE           = errors.New("DA record does not exist")
 	ErrDAUATS          = errors.New("Unable to save new Request")
+	ErrNoSecret        = errors.New("scheduler.secret must be set to sign scheduler callbacks")
+	ErrInvalidDate     = errors.New("Date is Invalid use YYYY-MM-DD")
+	ErrInvalidClass    = errors.New("Error class is Invalid")
+	ErrSessionDNE      = errors.New("Session does not exist")
+	ErrNoFailedItems   = errors.New("Session has no failed items")
+	ErrEmptyOPML       = errors.New("OPML has no subscriptions")
+	ErrDuplicateURL    = errors.New("A DA with this URL already exists")
+	ErrExportVersion   = errors.New("Export version is newer than this server supports")
+	ErrListedTwice     = errors.New("DA is listed more than once")
+	ErrNoName          = errors.New("Subscription name is Required")
+	ErrPruneRefused    = errors.New("Subscriptions would prune too many DAs, not pruning")
+	ErrReplaceAll      = errors.New("Replace would remove every DA, force it to import an empty document")
+	ErrUntrustedHost   = errors.New("Host can not carry an API key in links, set interface.public_url or interface.hosts")
 )
 
+// ImportResult is what became of one outline of an OPML import.
+type ImportResult struct {
+	Title string
+	URL   string
+	Id    string `json:",omitempty"`
+	Error string `json:",omitempty"`
+}
+
+// maxNameTries is how often create picks another name for a DA whose
+// name was taken while it was stored.
+const maxNameTries = 3
+
+// searchLimit caps the number of items Search returns.
+const searchLimit = 200
+
 // Get a new instance of the service.
 // If you want to add service middleware this is the place to put them.
-func New(db *gorm.DB, d da.Downloader) (s MdaService) {
-	s = &stubMdaService{db, d}
+func New(db *gorm.DB, d da.Downloader, providers *da.Registry, layout da.Layout) (s MdaService) {
+	s = &stubMdaService{db, d, providers, layout}
 	return s
 }
 
+// dbFor returns the database handle bound to ctx for tracing.
+func (md *stubMdaService) dbFor(ctx context.Context) *gorm.DB {
+	return tracing.DB(ctx, md.db)
+}
+
 // Implement the business logic of Add
 func (md *stubMdaService) Add(ctx context.Context, req da.DA) (id string, err error) {
+	req.ID, req.Managed = "", false
+	return md.create(ctx, req)
+}
+
+// validate checks a DA the way Add does before it is stored and resolves its
+// Location.
+func (md *stubMdaService) validate(ctx context.Context, req *da.DA) (err error) {
+	if k, ok := limited(ctx); ok && req.Owner != k.Owner {
+		return auth.ErrForbidden
+	}
+	if req.ID != "" {
+		if err := da.ValidateID(req.ID); err != nil {
+			return err
+		}
+	}
 	if req.Startdate == nil || req.Startdate.IsZero() {
-		return id, fmt.Errorf("Start time cannot be left blank")
+		return fmt.Errorf("Start time cannot be left blank")
 	}
 	if req.URL == "" {
-		return id, fmt.Errorf("URL IS Required")
+		return fmt.Errorf("URL IS Required")
+	}
+	if req.Name != "" {
+		if err := md.checkName(ctx, req.Name, req.ID); err != nil {
+			return err
+		}
+	}
+	if req.Location, err = md.resolveLocation(req.Location, req.URL); err != nil {
+		return err
+	}
+	if err := req.Steps.Validate(); err != nil {
+		return err
+	}
+	if !md.hasBackend(req.Backend) {
+		return da.ErrUnknownBackend
 	}
-	if err := md.db.Create(&req).Error; err != nil {
+	if !md.da.HasStorage(req.Storage) {
+		return da.ErrUnknownStorage
+	}
+	return da.ValidateLayout(viper.GetString("interface.home"), req, md.layout)
+}
+
+// defaultOwner gives a new DA without an Owner to the caller's key.
+func defaultOwner(ctx context.Context, req *da.DA) {
+	if k, ok := auth.FromContext(ctx); ok && req.Owner == "" {
+		req.Owner = k.Owner
+	}
+}
+
+// create validates and stores req, keeping its ID when set, and adds it to
+// the scheduler when one is set up.
+func (md *stubMdaService) create(ctx context.Context, req da.DA) (id string, err error) {
+	defaultOwner(ctx, &req)
+	if err := md.validate(ctx, &req); err != nil {
+		return id, err
+	}
+	named := req.Name != ""
+	err = md.dbFor(ctx).Create(&req).Error
+	// Another DA can take the name between checking and storing it. A name
+	// that was picked is picked again, one that was asked for is refused.
+	for tries := 0; !named && tries < maxNameTries && da.NameConflict(md.dbFor(ctx), err, req.Name, req.ID); tries++ {
+		req.Name = ""
+		err = md.dbFor(ctx).Create(&req).Error
+	}
+	if da.NameConflict(md.dbFor(ctx), err, req.Name, req.ID) {
+		return id, da.ErrNameTaken
+	}
+	if err != nil {
 		err = fmt.Errorf("Error: %s;\nDatabaseError:%s", ErrDAUATS, err.Error())
 		return id, err
 	}
 	id = req.ID
-	log.Debugf("%+v", req)
-	err = md.AddToSchedular(ctx, id)
+	log.WithFields(log.Fields{"da": id, "name": req.Name, "request_id": tracing.RequestID(ctx)}).Debugf("Created %+v", req)
+	md.schedule(ctx, &req)
+	return id, nil
+}
+
+// schedule adds d to the remote scheduler when one is set up. Failing to is
+// only logged, d is stored either way and can still be started by hand.
+func (md *stubMdaService) schedule(ctx context.Context, d *da.DA) {
+	err := md.AddToSchedular(ctx, d.ID)
+	if err == ErrNoSecret {
+		log.WithFields(log.Fields{"da": d.ID, "name": d.Name, "request_id": tracing.RequestID(ctx)}).
+			Debug("DA stored but not scheduled, scheduler.secret is not set")
+		return
+	}
 	if err != nil {
-		log.Debug("DA created but could not be added to remote schedular for reason %s", err.Error())
-		return id, nil
+		log.WithFields(log.Fields{"da": d.ID, "name": d.Name, "request_id": tracing.RequestID(ctx)}).WithError(err).
+			Warn("DA stored but could not be added to remote schedular")
 	}
-	return id, err
 }
 
 // Implement the business logic of Start
@@ -97,7 +265,7 @@ func (md *stubMdaService) Start(ctx context.Context, id string) (message string,
 		return "", err
 	}
 
-	err = md.da.Add(d)
+	err = md.da.Add(ctx, d)
 	if err != nil {
 		return "", err
 	}
@@ -111,7 +279,7 @@ func (md *stubMdaService) Remove(ctx context.Context, id string) (message string
 	if err != nil {
 		return "", err
 	}
-	if err := md.db.Delete(d).Error; err != nil {
+	if err := md.dbFor(ctx).Delete(d).Error; err != nil {
 		err = fmt.Errorf("Unable to delete from database\nError:%s", err.Error())
 		return "", err
 	}
@@ -125,8 +293,53 @@ func (md *stubMdaService) Change(ctx context.Context, id string, req da.DA) (mes
 	if err != nil {
 		return "", err
 	}
-	log.Debugf("%+v", req.Parameters)
-	if err := md.db.Model(d).Update(req).Error; err != nil {
+	// The key of a DA and whether the subscriptions config manages it are
+	// not for clients to change.
+	req.ID, req.Managed = "", false
+	if req.URL != "" || req.Location != "" {
+		u, loc := req.URL, req.Location
+		if u == "" {
+			u = d.URL
+		}
+		if loc == "" && req.URL == "" {
+			loc = d.Location
+		}
+		if req.Location, err = md.resolveLocation(loc, u); err != nil {
+			return "", err
+		}
+	}
+	if k, ok := limited(ctx); ok && req.Owner != "" && req.Owner != k.Owner {
+		return "", auth.ErrForbidden
+	}
+	if req.Name != "" {
+		if err := md.checkName(ctx, req.Name, d.ID); err != nil {
+			return "", err
+		}
+	}
+	if err := req.Steps.Validate(); err != nil {
+		return "", err
+	}
+	if !md.hasBackend(req.Backend) {
+		return "", da.ErrUnknownBackend
+	}
+	if !md.da.HasStorage(req.Storage) {
+		return "", da.ErrUnknownStorage
+	}
+	merged := *d
+	if req.Layout != nil {
+		merged.Layout = req.Layout
+	}
+	if req.Parameters != nil {
+		merged.Parameters = req.Parameters
+	}
+	if err := da.ValidateLayout(viper.GetString("interface.home"), &merged, md.layout); err != nil {
+		return "", err
+	}
+	log.WithFields(log.Fields{"da": d.ID, "name": d.Name, "request_id": tracing.RequestID(ctx)}).Debugf("Changing parameters to %+v", req.Parameters)
+	if err := md.dbFor(ctx).Model(d).Update(req).Error; err != nil {
+		if da.NameConflict(md.dbFor(ctx), err, req.Name, d.ID) {
+			return "", da.ErrNameTaken
+		}
 		err = fmt.Errorf("Cannot Update record with id %s;Database Error:%s", id, err.Error())
 		return "", err
 	}
@@ -136,20 +349,33 @@ func (md *stubMdaService) Change(ctx context.Context, id string, req da.DA) (mes
 }
 
 // Implement the business logic of Get
+// id is either the ID or the Name of the DA.
 func (md *stubMdaService) Get(ctx context.Context, id string) (result *da.DA, err error) {
 	dd := &da.DA{}
-	if err := md.db.Where(da.DA{ID: id}).First(dd).Error; err != nil {
-		err = fmt.Errorf("Error: %s;\nDatabaseError:%s", ErrDaDNE, err.Error())
-		return nil, err
+	db := md.scoped(ctx).Where("id = ? OR (name = ? AND name <> '')", id, id).First(dd)
+	if db.RecordNotFound() {
+		return nil, ErrDaDNE
+	}
+	if db.Error != nil {
+		return nil, fmt.Errorf("Error: %s;\nDatabaseError:%s", ErrDaDNE, db.Error.Error())
 	}
 	result = dd
 	return result, err
 }
 
 // Implement the business logic of List
-func (md *stubMdaService) List(ctx context.Context) (results *[]da.DA, err error) {
+// A class only returns DAs whose latest session failed with it.
+func (md *stubMdaService) List(ctx context.Context, class string) (results *[]da.DA, err error) {
+	db := md.scoped(ctx)
+	if class != "" {
+		if _, ok := da.ParseErrorClass(class); !ok {
+			return nil, ErrInvalidClass
+		}
+		db = db.Where("id IN (SELECT s.id FROM stats s WHERE s.error_class = ? AND s.ran_at = "+
+			"(SELECT MAX(l.ran_at) FROM stats l WHERE l.id = s.id))", class)
+	}
 	d := &[]da.DA{}
-	if err := md.db.Find(d).Error; err != nil {
+	if err := db.Find(d).Error; err != nil {
 		return nil, err
 	}
 	results = d
@@ -162,7 +388,7 @@ func (md *stubMdaService) Enable(ctx context.Context, id string) (message string
 	if err != nil {
 		return "", err
 	}
-	if err := md.db.Model(d).Update(da.DA{Enabled: true}).Error; err != nil {
+	if err := md.dbFor(ctx).Model(d).Update(da.DA{Enabled: true}).Error; err != nil {
 		err = fmt.Errorf("Cannot Enable record with id %s;Database Error:%s", id, err.Error())
 		return "", err
 	}
@@ -176,7 +402,7 @@ func (md *stubMdaService) Disable(ctx context.Context, id string) (message strin
 	if err != nil {
 		return "", err
 	}
-	if err := md.db.Model(d).Update(da.DA{Enabled: false}).Error; err != nil {
+	if err := md.dbFor(ctx).Model(d).Update(da.DA{Enabled: false}).Error; err != nil {
 		err = fmt.Errorf("Cannot Disable record with id %s;Database Error:%s", id, err.Error())
 		return "", err
 	}
@@ -184,11 +410,621 @@ func (md *stubMdaService) Disable(ctx context.Context, id string) (message strin
 	return message, err
 }
 
+// Implement the business logic of Providers
+func (md *stubMdaService) Providers(ctx context.Context) (results []da.Provider, err error) {
+	return md.providers.List(), nil
+}
+
+// Implement the business logic of Items
+func (md *stubMdaService) Items(ctx context.Context, id string) (results []da.MediaItem, err error) {
+	d, err := md.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	results = []da.MediaItem{}
+	if err := md.dbFor(ctx).Preload("Steps").Where(da.MediaItem{DA: d.ID}).Order("upload_date desc").Find(&results).Error; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Implement the business logic of Search
+// Keys without admin scope only find the items of their own DAs.
+func (md *stubMdaService) Search(ctx context.Context, query string) (results []da.MediaItem, err error) {
+	db := md.dbFor(ctx)
+	if k, ok := limited(ctx); ok {
+		db = db.Where("da IN (SELECT id FROM das WHERE owner = ?)", k.Owner)
+	}
+	if query != "" {
+		like := "%" + strings.ToLower(query) + "%"
+		db = db.Where("LOWER(title) LIKE ? OR LOWER(uploader) LIKE ? OR video_id = ?", like, like, query)
+	}
+	results = []da.MediaItem{}
+	if err := db.Order("upload_date desc").Limit(searchLimit).Find(&results).Error; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Implement the business logic of Archive
+func (md *stubMdaService) Archive(ctx context.Context, id string) (results []da.ArchiveEntry, err error) {
+	d, err := md.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	results = []da.ArchiveEntry{}
+	if err := md.dbFor(ctx).Where(da.ArchiveEntry{DA: d.ID}).Order("created_at desc").Find(&results).Error; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Implement the business logic of ResetArchive
+func (md *stubMdaService) ResetArchive(ctx context.Context, id string) (message string, err error) {
+	d, err := md.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	db := md.dbFor(ctx).Where(da.ArchiveEntry{DA: d.ID}).Delete(da.ArchiveEntry{})
+	if db.Error != nil {
+		return "", fmt.Errorf("Cannot reset archive of record with id %s;Database Error:%s", id, db.Error.Error())
+	}
+	message = fmt.Sprintf("Removed %d archive entries of DA with id %s", db.RowsAffected, d.ID)
+	return message, nil
+}
+
+// Implement the business logic of PruneArchive
+// Entries whose indexed file is gone from disk are removed so the next run
+// downloads them again.
+func (md *stubMdaService) PruneArchive(ctx context.Context, id string) (message string, err error) {
+	entries, err := md.Archive(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	db := md.dbFor(ctx)
+	pruned := 0
+	for _, e := range entries {
+		item := da.MediaItem{}
+		if db.Where(da.MediaItem{DA: e.DA, Extractor: e.Extractor, VideoID: e.VideoID}).First(&item).RecordNotFound() {
+			continue
+		}
+		if _, err := os.Stat(item.Path); item.StorageURL != "" || !os.IsNotExist(err) {
+			continue
+		}
+		if err := db.Where(da.ArchiveEntry{DA: e.DA, Extractor: e.Extractor, VideoID: e.VideoID}).Delete(da.ArchiveEntry{}).Error; err != nil {
+			return "", fmt.Errorf("Cannot prune archive of record with id %s;Database Error:%s", id, err.Error())
+		}
+		pruned++
+	}
+	message = fmt.Sprintf("Pruned %d of %d archive entries of DA with id %s", pruned, len(entries), id)
+	return message, nil
+}
+
+// Implement the business logic of Rewind
+// The next run downloads items uploaded on or after to again, items in the
+// download archive are still skipped.
+func (md *stubMdaService) Rewind(ctx context.Context, id string, to time.Time) (message string, err error) {
+	if to.IsZero() {
+		return "", ErrInvalidDate
+	}
+	d, err := md.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if err := md.dbFor(ctx).Model(d).UpdateColumn("currentdate", to).Error; err != nil {
+		err = fmt.Errorf("Cannot Rewind record with id %s;Database Error:%s", id, err.Error())
+		return "", err
+	}
+	message = fmt.Sprintf("DA with id %s has been rewound to %s", d.ID, to.Format("2006-01-02"))
+	if d.Startdate != nil && to.Before(*d.Startdate) {
+		message += fmt.Sprintf(", items before its start date %s are still skipped", d.Startdate.Format("2006-01-02"))
+	}
+	return message, nil
+}
+
+// Implement the business logic of History
+func (md *stubMdaService) History(ctx context.Context, id string, class string) (results []da.Stats, err error) {
+	d, err := md.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	where := da.Stats{ID: d.ID}
+	if class != "" {
+		c, ok := da.ParseErrorClass(class)
+		if !ok {
+			return nil, ErrInvalidClass
+		}
+		where.ErrorClass = c
+	}
+	results = []da.Stats{}
+	if err := md.dbFor(ctx).Where(where).Order("ran_at desc").Find(&results).Error; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Implement the business logic of Results
+func (md *stubMdaService) Results(ctx context.Context, session string) (results []da.ItemResult, err error) {
+	if _, err := md.session(ctx, session); err != nil {
+		return nil, err
+	}
+	results = []da.ItemResult{}
+	if err := md.dbFor(ctx).Where(da.ItemResult{Session: session}).Order("created_at").Find(&results).Error; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Implement the business logic of RetryFailed
+func (md *stubMdaService) RetryFailed(ctx context.Context, session string) (message string, err error) {
+	s, err := md.session(ctx, session)
+	if err != nil {
+		return "", err
+	}
+	failed := []da.ItemResult{}
+	if err := md.dbFor(ctx).Where(da.ItemResult{Session: session, Status: da.ItemFailed}).Find(&failed).Error; err != nil {
+		return "", err
+	}
+	items := make([]string, 0, len(failed))
+	for _, r := range failed {
+		if r.URL != "" {
+			items = append(items, r.URL)
+		}
+	}
+	if len(items) == 0 {
+		return "", ErrNoFailedItems
+	}
+	d, err := md.Get(ctx, s.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := md.da.AddItems(ctx, d, items); err != nil {
+		return "", err
+	}
+	message = fmt.Sprintf("Retrying %d failed items of session %s of DA with id %s", len(items), session, d.ID)
+	return message, nil
+}
+
+// Implement the business logic of Clean
+func (md *stubMdaService) Clean(ctx context.Context, id string, dryRun bool) (report *da.RetentionReport, err error) {
+	d, err := md.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return md.da.Clean(ctx, d, dryRun)
+}
+
+// Implement the business logic of Janitor
+func (md *stubMdaService) Janitor(ctx context.Context, dryRun bool) (reports []*da.RetentionReport, err error) {
+	return md.da.Janitor(ctx, dryRun)
+}
+
+// Implement the business logic of Feed
+func (md *stubMdaService) Feed(ctx context.Context, id string, links feed.Links) (result *feed.RSS, err error) {
+	d, err := md.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	items, err := md.Items(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if links, err = publicLinks(links); err != nil {
+		return nil, err
+	}
+	return feed.New(d, items, viper.GetString("interface.home"), links), nil
+}
+
+// Implement the business logic of FeedAll
+func (md *stubMdaService) FeedAll(ctx context.Context, links feed.Links) (result *feed.RSS, err error) {
+	das, err := md.owned(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(das))
+	for i, d := range das {
+		ids[i] = d.ID
+	}
+	items := []da.MediaItem{}
+	if len(ids) > 0 {
+		if err := md.dbFor(ctx).Where("da in (?)", ids).Find(&items).Error; err != nil {
+			return nil, err
+		}
+	}
+	if links, err = publicLinks(links); err != nil {
+		return nil, err
+	}
+	return feed.All(items, viper.GetString("interface.home"), links), nil
+}
+
+// Implement the business logic of ExportOPML
+func (md *stubMdaService) ExportOPML(ctx context.Context, links feed.Links) (result *feed.OPML, err error) {
+	das, err := md.owned(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The document is meant to be shared, the key of the caller stays out.
+	links.Key = ""
+	if links, err = publicLinks(links); err != nil {
+		return nil, err
+	}
+	return feed.NewOPML(das, links), nil
+}
+
+// Implement the business logic of ImportOPML
+// Every subscription of doc goes through Add with the fields of defaults
+// the outline does not set. URLs that already have a DA are skipped.
+func (md *stubMdaService) ImportOPML(ctx context.Context, doc feed.OPML, defaults da.DA) (results []ImportResult, err error) {
+	subs := doc.Subscriptions()
+	if len(subs) == 0 {
+		return nil, ErrEmptyOPML
+	}
+	results = make([]ImportResult, 0, len(subs))
+	for _, o := range subs {
+		r := ImportResult{Title: o.Title, URL: o.URL()}
+		if r.Title == "" {
+			r.Title = o.Text
+		}
+		req := defaults
+		req.URL = o.URL()
+		if o.Location != "" {
+			req.Location = o.Location
+		}
+		if o.Frequency != "" {
+			req.Frequency = o.Frequency
+		}
+		if t, ok := o.Start(); ok {
+			req.Startdate = &t
+		}
+		if !md.dbFor(ctx).Where(da.DA{URL: req.URL}).First(&da.DA{}).RecordNotFound() {
+			r.Error = ErrDuplicateURL.Error()
+		} else if r.Id, err = md.Add(ctx, req); err != nil {
+			r.Error = err.Error()
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// Implement the business logic of Export
+func (md *stubMdaService) Export(ctx context.Context) (result *Export, err error) {
+	das, err := md.owned(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result = &Export{Version: ExportVersion, Subscriptions: make([]Subscription, len(das))}
+	for i, d := range das {
+		result.Subscriptions[i] = subscriptionOf(d)
+	}
+	return result, nil
+}
+
+// Implement the business logic of Import
+// Subscriptions are matched to DAs by ID, or by Name and then URL when they
+// have none.
+// Every DA import creates or updates is validated like Add, invalid ones are
+// reported and left alone. A dry run only reports what would change.
+// Replacing every DA with an empty document takes force.
+func (md *stubMdaService) Import(ctx context.Context, doc Export, mode ImportMode, dryRun, force bool) (result *ImportReport, err error) {
+	if mode, err = ParseImportMode(string(mode)); err != nil {
+		return nil, err
+	}
+	if doc.Version > ExportVersion {
+		return nil, ErrExportVersion
+	}
+	existing, err := md.owned(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mode == ImportReplace && len(doc.Subscriptions) == 0 && len(existing) > 0 && !force {
+		return nil, ErrReplaceAll
+	}
+	byID := make(map[string]*da.DA, len(existing))
+	byName := make(map[string]*da.DA, len(existing))
+	byURL := make(map[string]*da.DA, len(existing))
+	for i := range existing {
+		byID[existing[i].ID] = &existing[i]
+		byName[existing[i].Name] = &existing[i]
+		byURL[existing[i].URL] = &existing[i]
+	}
+	seen := make(map[string]bool)
+	result = &ImportReport{Mode: mode, DryRun: dryRun, Changes: []ImportChange{}}
+	for _, sub := range doc.Subscriptions {
+		c := ImportChange{ID: sub.ID, Name: sub.Name, URL: sub.URL}
+		stored, ok := byID[sub.ID]
+		if sub.ID == "" && sub.Name != "" {
+			stored, ok = byName[sub.Name]
+		}
+		if sub.ID == "" && !ok {
+			stored, ok = byURL[sub.URL]
+		}
+		switch {
+		case ok && seen[stored.ID]:
+			c.Action, c.Error = ActionInvalid, ErrListedTwice.Error()
+		case ok && mode == ImportSkip:
+			seen[stored.ID] = true
+			c.Action, c.ID = ActionSkip, stored.ID
+		case ok:
+			seen[stored.ID] = true
+			c.ID = stored.ID
+			merged := sub.over(*stored)
+			if c.Fields = changedFields(subscriptionOf(*stored), subscriptionOf(merged)); len(c.Fields) == 0 {
+				c.Action = ActionUnchanged
+				break
+			}
+			c.Action = ActionUpdate
+			if err := md.validate(ctx, &merged); err != nil {
+				c.Action, c.Error = ActionInvalid, err.Error()
+				break
+			}
+			if dryRun {
+				break
+			}
+			if err := md.dbFor(ctx).Save(&merged).Error; err != nil {
+				c.Error = fmt.Sprintf("Cannot Update record with id %s;Database Error:%s", stored.ID, err.Error())
+			}
+		default:
+			c.Action = ActionCreate
+			d := sub.DA()
+			if dryRun {
+				defaultOwner(ctx, &d)
+				err = md.validate(ctx, &d)
+			} else if c.ID, err = md.create(ctx, d); c.ID == "" {
+				c.ID = sub.ID
+			}
+			if err != nil {
+				c.Action, c.Error = ActionInvalid, err.Error()
+			}
+		}
+		result.Changes = append(result.Changes, c)
+	}
+	if mode != ImportReplace {
+		return result, nil
+	}
+	for _, d := range existing {
+		if seen[d.ID] {
+			continue
+		}
+		c := ImportChange{Action: ActionRemove, ID: d.ID, Name: d.Name, URL: d.URL}
+		if !dryRun {
+			if _, err := md.Remove(ctx, d.ID); err != nil {
+				c.Error = err.Error()
+			}
+		}
+		result.Changes = append(result.Changes, c)
+	}
+	return result, nil
+}
+
+// Implement the business logic of Reconcile
+// DAs are matched on Name. Listed DAs are created, or updated and taken
+// over, and managed DAs that are no longer listed are removed when prune
+// is set and mayPrune agrees.
+func (md *stubMdaService) Reconcile(ctx context.Context, subs []Subscription, prune bool, maxPrune float64) (result *ImportReport, err error) {
+	existing := []da.DA{}
+	if err := md.dbFor(ctx).Where("name <> '' OR managed = ?", true).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*da.DA, len(existing))
+	for i := range existing {
+		if existing[i].Name != "" {
+			byName[existing[i].Name] = &existing[i]
+		}
+	}
+	result = &ImportReport{Mode: ImportMerge, Changes: []ImportChange{}}
+	if prune {
+		result.Mode = ImportReplace
+	}
+	listed := make(map[string]bool)
+	for _, sub := range subs {
+		c := ImportChange{Name: sub.Name, URL: sub.URL}
+		stored, ok := byName[sub.Name]
+		switch {
+		case sub.Name == "":
+			c.Action, c.Error = ActionInvalid, ErrNoName.Error()
+		case listed[sub.Name]:
+			c.Action, c.Error = ActionInvalid, ErrListedTwice.Error()
+		case ok:
+			listed[sub.Name] = true
+			c.ID = stored.ID
+			merged := sub.over(*stored)
+			merged.Managed = true
+			c.Fields = changedFields(subscriptionOf(*stored), subscriptionOf(merged))
+			if !stored.Managed {
+				c.Fields = append(c.Fields, "Managed")
+			}
+			if len(c.Fields) == 0 {
+				c.Action = ActionUnchanged
+				break
+			}
+			c.Action = ActionUpdate
+			if err := md.validate(ctx, &merged); err != nil {
+				c.Action, c.Error = ActionInvalid, err.Error()
+				break
+			}
+			if err := md.dbFor(ctx).Save(&merged).Error; err != nil {
+				c.Error = fmt.Sprintf("Cannot Update record with id %s;Database Error:%s", stored.ID, err.Error())
+				break
+			}
+			// A changed frequency only takes effect once the job is added
+			// again.
+			md.schedule(ctx, &merged)
+		default:
+			listed[sub.Name] = true
+			c.Action = ActionCreate
+			d := sub.DA()
+			d.ID, d.Managed = "", true
+			id, err := md.create(ctx, d)
+			if err != nil {
+				c.Action, c.Error = ActionInvalid, err.Error()
+			}
+			c.ID = id
+		}
+		result.Changes = append(result.Changes, c)
+	}
+	managed, orphaned := 0, 0
+	for _, d := range existing {
+		if d.Managed {
+			managed++
+			if !listed[d.Name] {
+				orphaned++
+			}
+		}
+	}
+	refused := prune && !mayPrune(len(listed), managed, orphaned, maxPrune)
+	if refused {
+		prune, result.Mode = false, ImportMerge
+	}
+	for _, d := range existing {
+		if !d.Managed || listed[d.Name] {
+			continue
+		}
+		c := ImportChange{Action: ActionOrphan, ID: d.ID, Name: d.Name, URL: d.URL}
+		if refused {
+			c.Error = ErrPruneRefused.Error()
+		}
+		if prune {
+			c.Action = ActionRemove
+			if _, err := md.Remove(ctx, d.ID); err != nil {
+				c.Error = err.Error()
+			}
+		}
+		result.Changes = append(result.Changes, c)
+	}
+	return result, nil
+}
+
+// DefaultMaxPrune is the share of the managed DAs a reconcile may prune
+// unless subscriptions.max_prune says otherwise.
+const DefaultMaxPrune = 0.5
+
+// mayPrune reports whether removing orphaned of the managed DAs is safe. A
+// list that is empty or drops more than maxPrune of them looks like a
+// broken document rather than an edit, 1 allows any partial list.
+func mayPrune(listed, managed, orphaned int, maxPrune float64) bool {
+	if orphaned == 0 {
+		return true
+	}
+	if listed == 0 {
+		return false
+	}
+	if maxPrune <= 0 {
+		maxPrune = DefaultMaxPrune
+	}
+	return float64(orphaned) <= maxPrune*float64(managed)
+}
+
+// limited returns the key of a caller that only sees the DAs it owns, any
+// key without admin scope.
+func limited(ctx context.Context) (*auth.APIKey, bool) {
+	k, ok := auth.FromContext(ctx)
+	return k, ok && !k.Allows(auth.ScopeAdmin)
+}
+
+// scoped limits the DAs db finds to those of the caller. DAs without an
+// Owner, like those stored before keys had one, are only seen by admin keys
+// until auth.default_owner assigns them.
+func (md *stubMdaService) scoped(ctx context.Context) *gorm.DB {
+	db := md.dbFor(ctx)
+	if k, ok := limited(ctx); ok {
+		db = db.Where("owner = ?", k.Owner)
+	}
+	return db
+}
+
+// owned returns the DAs of the caller, every DA when auth is off or the
+// caller has an admin key.
+func (md *stubMdaService) owned(ctx context.Context) ([]da.DA, error) {
+	das := []da.DA{}
+	if err := md.scoped(ctx).Find(&das).Error; err != nil {
+		return nil, err
+	}
+	return das, nil
+}
+
+// publicLinks puts links on interface.public_url, when set, instead of the
+// address the request came in on. Links carrying a key are only put on a
+// request address listed in interface.hosts, the Host header is the
+// client's to choose.
+func publicLinks(links feed.Links) (feed.Links, error) {
+	if u := viper.GetString("interface.public_url"); u != "" {
+		links.Base = u
+		return links, nil
+	}
+	if links.Key == "" {
+		return links, nil
+	}
+	u, err := url.Parse(links.Base)
+	if err != nil {
+		return links, ErrUntrustedHost
+	}
+	for _, h := range viper.GetStringSlice("interface.hosts") {
+		if strings.EqualFold(h, u.Host) || strings.EqualFold(h, u.Hostname()) {
+			return links, nil
+		}
+	}
+	return links, ErrUntrustedHost
+}
+
+func (md *stubMdaService) session(ctx context.Context, session string) (*da.Stats, error) {
+	s := &da.Stats{}
+	if md.dbFor(ctx).Where(da.Stats{Session: session}).First(s).RecordNotFound() {
+		return nil, ErrSessionDNE
+	}
+	if _, err := md.Get(ctx, s.ID); err != nil {
+		return nil, ErrSessionDNE
+	}
+	return s, nil
+}
+
+// hasBackend reports whether DAs may name the backend name, empty picks
+// one for them.
+func (md *stubMdaService) hasBackend(name string) bool {
+	if name == "" {
+		return true
+	}
+	for _, b := range md.da.Backends() {
+		if b.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkName checks that name is a valid Name no DA but the one with id has.
+func (md *stubMdaService) checkName(ctx context.Context, name, id string) error {
+	if err := da.ValidateName(name); err != nil {
+		return err
+	}
+	taken, err := da.NameTaken(md.dbFor(ctx), name, id)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return da.ErrNameTaken
+	}
+	return nil
+}
+
+// resolveLocation infers the provider of url, or checks location against
+// it, and reports anything unsupported as ErrInvalidLocation.
+func (md *stubMdaService) resolveLocation(location, url string) (string, error) {
+	loc, err := md.providers.Resolve(location, url)
+	if err != nil {
+		log.WithFields(log.Fields{"location": location, "url": url}).WithError(err).Debug("Rejecting location")
+		return "", ErrInvalidLocation
+	}
+	return loc, nil
+}
+
 func (md *stubMdaService) AddToSchedular(ctx context.Context, id string) error {
 	d, err := md.Get(ctx, id)
 	if err != nil {
 		return err
 	}
+	if viper.GetString("scheduler.secret") == "" {
+		return ErrNoSecret
+	}
 	cli, err := client.NewConsulClient(viper.GetString("consul_address"), viper.GetString("acl_token"))
 	if err != nil {
 		return err
@@ -212,12 +1048,24 @@ func (md *stubMdaService) AddToSchedular(ctx context.Context, id string) error {
 	//rr, errr := c.Start(context.Background(), &pb.StartRequest{})
 	//fmt.Printf("Error %+v\n", errr)
 	// Contact the server and print out its response.
+	// The job runs "mda callback" on the scheduler host, which signs every
+	// request with the shared secret so it can be checked by Start.
+	url, err := CallbackURL(d.ID)
+	if err != nil {
+		return err
+	}
+	command := []string{viper.GetString("scheduler.command"), "callback", "--id", d.ID, url}
 	ctxx := context.Background()
 	ctxx = metadata.NewOutgoingContext(ctx,
 		metadata.Pairs(apischeduler.JobUniqueness, "UNIQUE"))
+	// Jobs are deliberately named by the ID rather than the readable Name.
+	// The scheduler can only add jobs, so a job named after the slug would be
+	// left behind, still firing, once the DA is renamed, and Job has no
+	// description to carry the name. The name is logged here instead.
+	log.WithFields(log.Fields{"id": d.ID, "name": d.Name}).Debug("Scheduling DA")
 	_, err = c.Add(ctxx, &pb.AddRequest{Reqjob: &pb.Job{
-		Name:        fmt.Sprintf("%s", d.ID),
-		Command:     []string{"curl", "--request", "POST", fmt.Sprintf("http://%s:%d/mda/start/%s", GetOutboundIP().String(), viper.GetInt("interface.port"), d.ID)},
+		Name:        d.ID,
+		Command:     command,
 		Schedule:    fmt.Sprintf("R/%s/P1W", time.Now().Add(time.Second*10).UTC().Format(job.RFC3339WithoutTimezone)),
 		Application: "MDA",
 		Domain:      "Local Area",
@@ -227,15 +1075,3 @@ func (md *stubMdaService) AddToSchedular(ctx context.Context, id string) error {
 	}
 	return nil
 }
-
-func GetOutboundIP() net.IP {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer conn.Close()
-
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-
-	return localAddr.IP
-}
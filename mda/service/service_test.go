@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/will7200/mda/da"
+	"github.com/will7200/mda/mda/auth"
+)
+
+func TestLimited(t *testing.T) {
+	tests := []struct {
+		name string
+		key  *auth.APIKey
+		want bool
+	}{
+		{"auth off", nil, false},
+		{"read", &auth.APIKey{Owner: "ann", Scopes: "read"}, true},
+		{"write", &auth.APIKey{Owner: "ann", Scopes: "read,write"}, true},
+		{"admin", &auth.APIKey{Owner: "root", Scopes: "admin"}, false},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.key != nil {
+			ctx = auth.NewContext(ctx, tt.key)
+		}
+		k, got := limited(ctx)
+		if got != tt.want {
+			t.Errorf("%s: limited() = %v, want %v", tt.name, got, tt.want)
+		}
+		if got && k.Owner != tt.key.Owner {
+			t.Errorf("%s: limited() key owner = %q, want %q", tt.name, k.Owner, tt.key.Owner)
+		}
+	}
+}
+
+func TestMayPrune(t *testing.T) {
+	tests := []struct {
+		name                      string
+		listed, managed, orphaned int
+		maxPrune                  float64
+		want                      bool
+	}{
+		{"nothing to prune", 0, 0, 0, 0, true},
+		{"empty list", 0, 4, 4, 1, false},
+		{"one of four", 3, 4, 1, 0, true},
+		{"half by default", 2, 4, 2, 0, true},
+		{"three of four by default", 1, 4, 3, 0, false},
+		{"three of four allowed", 1, 4, 3, 1, true},
+		{"lower limit", 3, 4, 1, 0.2, false},
+	}
+	for _, tt := range tests {
+		if got := mayPrune(tt.listed, tt.managed, tt.orphaned, tt.maxPrune); got != tt.want {
+			t.Errorf("%s: mayPrune(%d, %d, %d, %v) = %v, want %v", tt.name, tt.listed, tt.managed, tt.orphaned, tt.maxPrune, got, tt.want)
+		}
+	}
+}
+
+// testDB is an empty in memory database with the tables of mda.
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a database of its own.
+	db.DB().SetMaxOpenConns(1)
+	da.CreateDatabaseTables(db)
+	return db
+}
+
+func TestGetUnknown(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+	md := &stubMdaService{db: db}
+	if _, err := md.Get(context.Background(), "missing"); err != ErrDaDNE {
+		t.Errorf("Get() of an unknown DA = %v, want %v", err, ErrDaDNE)
+	}
+}
+
+func TestSearchScope(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+	for _, d := range []da.DA{
+		{URL: "https://example.com/ann", Owner: "ann"},
+		{URL: "https://example.com/bob", Owner: "bob"},
+	} {
+		if err := db.Create(&d).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&da.MediaItem{DA: d.ID, Title: "Episode of " + d.Owner}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	md := &stubMdaService{db: db}
+	tests := []struct {
+		name  string
+		key   *auth.APIKey
+		query string
+		want  int
+	}{
+		{"auth off", nil, "episode", 2},
+		{"admin", &auth.APIKey{Owner: "root", Scopes: "admin"}, "episode", 2},
+		{"own items", &auth.APIKey{Owner: "ann", Scopes: "read"}, "episode", 1},
+		{"other items", &auth.APIKey{Owner: "ann", Scopes: "read"}, "bob", 0},
+		{"no query", &auth.APIKey{Owner: "bob", Scopes: "read"}, "", 1},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.key != nil {
+			ctx = auth.NewContext(ctx, tt.key)
+		}
+		got, err := md.Search(ctx, tt.query)
+		if err != nil {
+			t.Errorf("%s: Search() error = %v", tt.name, err)
+			continue
+		}
+		if len(got) != tt.want {
+			t.Errorf("%s: Search(%q) found %d items, want %d", tt.name, tt.query, len(got), tt.want)
+		}
+		for _, m := range got {
+			if tt.key != nil && tt.key.Scopes == "read" && m.Title != "Episode of "+tt.key.Owner {
+				t.Errorf("%s: Search(%q) found %q", tt.name, tt.query, m.Title)
+			}
+		}
+	}
+}
+
+func TestImportDryRunOwner(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+	md := &stubMdaService{db: db, da: da.NewDownloader("", db), providers: da.DefaultProviders()}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	doc := Export{Version: ExportVersion, Subscriptions: []Subscription{
+		{URL: "https://www.youtube.com/channel/abc", Enabled: true, Startdate: &start},
+	}}
+	ctx := auth.NewContext(context.Background(), &auth.APIKey{Owner: "ann", Scopes: "write"})
+	// A dry run reports what the import does, both take the key's owner.
+	for _, dryRun := range []bool{true, false} {
+		report, err := md.Import(ctx, doc, ImportMerge, dryRun, false)
+		if err != nil {
+			t.Fatalf("Import(dry run %v) = %v", dryRun, err)
+		}
+		if c := report.Changes[0]; c.Action != ActionCreate || c.Error != "" {
+			t.Errorf("Import(dry run %v) = %s, %q, want %s", dryRun, c.Action, c.Error, ActionCreate)
+		}
+	}
+	d := da.DA{}
+	if err := db.First(&d).Error; err != nil || d.Owner != "ann" {
+		t.Errorf("Import() stored owner %q, %v, want ann", d.Owner, err)
+	}
+}
+
+func TestChangeKeepsKey(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+	md := &stubMdaService{db: db, da: da.NewDownloader("", db), providers: da.DefaultProviders()}
+	d := da.DA{URL: "https://www.youtube.com/channel/abc", Location: "youtube"}
+	if err := db.Create(&d).Error; err != nil {
+		t.Fatal(err)
+	}
+	other := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	if _, err := md.Change(context.Background(), d.ID, da.DA{ID: other, Managed: true, Frequency: "daily"}); err != nil {
+		t.Fatalf("Change() = %v", err)
+	}
+	got := da.DA{}
+	if err := db.Where("id = ?", d.ID).First(&got).Error; err != nil {
+		t.Fatalf("Change() moved the DA: %v", err)
+	}
+	if got.Managed || got.Frequency != "daily" {
+		t.Errorf("Change() = managed %v, frequency %q, want only the frequency changed", got.Managed, got.Frequency)
+	}
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/will7200/mda/da"
+)
+
+// local copies files into a directory, such as a mounted network share.
+type local struct {
+	root string
+}
+
+// NewLocal returns a Storage writing below root.
+func NewLocal(root string) (da.Storage, error) {
+	if err := require(map[string]string{"root": root}, "root"); err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &local{root}, nil
+}
+
+func (l *local) Put(ctx context.Context, key, path string) (string, error) {
+	target := filepath.Join(l.root, filepath.FromSlash(join("", key)))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.Create(target + ".part")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := os.Rename(out.Name(), target); err != nil {
+		return "", err
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(target)}
+	return u.String(), nil
+}
+
+func (l *local) Delete(ctx context.Context, key string) error {
+	return os.Remove(filepath.Join(l.root, filepath.FromSlash(join("", key))))
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		root, key string
+		want      string
+	}{
+		{"", "show/ep1.mp3", "/show/ep1.mp3"},
+		{"/srv/media", "show/ep1.mp3", "/srv/media/show/ep1.mp3"},
+		{"media", "/show/./ep1.mp3", "/media/show/ep1.mp3"},
+		{"", "../../etc/passwd", "/etc/passwd"},
+		{"/srv/media", "show/../../../etc/passwd", "/srv/media/etc/passwd"},
+	}
+	for _, tt := range tests {
+		if got := join(tt.root, tt.key); got != tt.want {
+			t.Errorf("join(%q, %q) = %q, want %q", tt.root, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Type: "ftp"}); err != ErrUnknownType {
+		t.Errorf("New(ftp) = %v, want %v", err, ErrUnknownType)
+	}
+	if _, err := New(Config{Type: "local"}); err == nil || !strings.HasPrefix(err.Error(), ErrMissing.Error()) {
+		t.Errorf("New(local) without a root = %v, want %v", err, ErrMissing)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "download.mp3")
+	if err := ioutil.WriteFile(src, []byte("mp3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	s, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{"key", "show/ep1.mp3", "show/ep1.mp3"},
+		{"leading slash", "/show/ep2.mp3", "show/ep2.mp3"},
+		{"dot dot", "../../escaped.mp3", "escaped.mp3"},
+		{"dot dot inside", "show/../../../escaped2.mp3", "escaped2.mp3"},
+	}
+	for _, tt := range tests {
+		u, err := s.Put(context.Background(), tt.key, src)
+		if err != nil {
+			t.Errorf("%s: Put(%q) = %v", tt.name, tt.key, err)
+			continue
+		}
+		want := filepath.Join(root, filepath.FromSlash(tt.want))
+		if b, err := ioutil.ReadFile(want); err != nil || string(b) != "mp3" {
+			t.Errorf("%s: Put(%q) did not write %s: %v", tt.name, tt.key, want, err)
+		}
+		if u != "file://"+filepath.ToSlash(want) {
+			t.Errorf("%s: Put(%q) = %q, want the file URL of %s", tt.name, tt.key, u, want)
+		}
+		if _, err := os.Stat(want + ".part"); !os.IsNotExist(err) {
+			t.Errorf("%s: Put(%q) left the partial file behind", tt.name, tt.key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.mp3")); !os.IsNotExist(err) {
+		t.Error("Put() wrote outside the root")
+	}
+
+	// Deleting with .. stays inside the root too.
+	if err := ioutil.WriteFile(filepath.Join(dir, "keep.mp3"), []byte("mp3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(context.Background(), "../keep.mp3"); !os.IsNotExist(err) {
+		t.Errorf("Delete(../keep.mp3) = %v, want it not to exist in the root", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep.mp3")); err != nil {
+		t.Errorf("Delete() removed a file outside the root: %v", err)
+	}
+	if err := s.Delete(context.Background(), "show/ep1.mp3"); err != nil {
+		t.Errorf("Delete(show/ep1.mp3) = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "show", "ep1.mp3")); !os.IsNotExist(err) {
+		t.Error("Delete() left the file")
+	}
+}
+
+func TestNewLocalRelative(t *testing.T) {
+	s, err := NewLocal("media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root := s.(*local).root; !filepath.IsAbs(root) {
+		t.Errorf("NewLocal(media) root = %q, want it absolute", root)
+	}
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go"
+	"github.com/will7200/mda/da"
+)
+
+// s3 uploads to a bucket of an S3 compatible object storage such as MinIO.
+type s3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 returns a Storage uploading into a bucket.
+func NewS3(opts map[string]string) (da.Storage, error) {
+	if err := require(opts, "endpoint", "bucket"); err != nil {
+		return nil, err
+	}
+	client, err := minio.NewWithRegion(opts["endpoint"], opts["access_key"], opts["secret_key"],
+		opts["secure"] != "false", opts["region"])
+	if err != nil {
+		return nil, err
+	}
+	return &s3{client: client, bucket: opts["bucket"], prefix: strings.Trim(opts["prefix"], "/")}, nil
+}
+
+func (s *s3) object(key string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, key), "/")
+}
+
+func (s *s3) Put(ctx context.Context, key, file string) (string, error) {
+	object := s.object(key)
+	_, err := s.client.FPutObjectWithContext(ctx, s.bucket, object, file, minio.PutObjectOptions{
+		ContentType: mime.TypeByExtension(path.Ext(file)),
+	})
+	if err != nil {
+		return "", err
+	}
+	u := *s.client.EndpointURL()
+	u.Path = path.Join("/", s.bucket, object)
+	return u.String(), nil
+}
+
+func (s *s3) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.client.RemoveObject(s.bucket, s.object(key))
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/will7200/mda/da"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpStorage uploads over SFTP, connecting for every call.
+type sftpStorage struct {
+	address string
+	root    string
+	config  *ssh.ClientConfig
+}
+
+// defaultSFTPTimeout bounds connecting and the SSH handshake unless the
+// timeout option sets another duration.
+const defaultSFTPTimeout = 30 * time.Second
+
+// NewSFTP returns a Storage uploading below root on an SSH server. Host keys
+// are checked against known_hosts unless it is set to "insecure".
+func NewSFTP(opts map[string]string) (da.Storage, error) {
+	if err := require(opts, "address", "username"); err != nil {
+		return nil, err
+	}
+	config := &ssh.ClientConfig{User: opts["username"], Timeout: defaultSFTPTimeout}
+	if t := opts["timeout"]; t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, err
+		}
+		config.Timeout = d
+	}
+	if p := opts["password"]; p != "" {
+		config.Auth = append(config.Auth, ssh.Password(p))
+	}
+	if f := opts["key_file"]; f != "" {
+		pem, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, err
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	switch kh := opts["known_hosts"]; kh {
+	case "insecure":
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		if kh == "" {
+			kh = os.ExpandEnv("$HOME/.ssh/known_hosts")
+		}
+		cb, err := knownhosts.New(kh)
+		if err != nil {
+			return nil, err
+		}
+		config.HostKeyCallback = cb
+	}
+	return &sftpStorage{address: opts["address"], root: opts["root"], config: config}, nil
+}
+
+// connect opens a session that is closed, failing what runs on it, once ctx
+// is done.
+func (s *sftpStorage) connect(ctx context.Context) (*sftp.Client, func(), error) {
+	d := net.Dialer{Timeout: s.config.Timeout}
+	nc, err := d.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return nil, nil, err
+	}
+	nc.SetDeadline(time.Now().Add(s.config.Timeout))
+	c, chans, reqs, err := ssh.NewClientConn(nc, s.address, s.config)
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	conn := ssh.NewClient(c, chans, reqs)
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	return client, func() { close(stop); client.Close(); conn.Close() }, nil
+}
+
+func (s *sftpStorage) Put(ctx context.Context, key, file string) (string, error) {
+	client, done, err := s.connect(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+	target := join(s.root, key)
+	if err := client.MkdirAll(path.Dir(target)); err != nil {
+		return "", err
+	}
+	in, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := client.Create(target)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return "", err
+	}
+	return "sftp://" + s.config.User + "@" + s.address + target, nil
+}
+
+func (s *sftpStorage) Delete(ctx context.Context, key string) error {
+	client, done, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return client.Remove(join(s.root, key))
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/will7200/mda/da"
+)
+
+var (
+	ErrUnknownType = errors.New("Storage type is not supported")
+	ErrMissing     = errors.New("Storage option is required")
+)
+
+// Config describes a storage in the storage section of the config file.
+// Options depend on the Type:
+//
+//	local   root
+//	s3      endpoint, bucket, access_key, secret_key, region, secure, prefix
+//	webdav  url, username, password
+//	sftp    address, username, password, key_file, known_hosts, root, timeout
+//
+// DeleteLocal removes the downloaded file once it has been uploaded.
+type Config struct {
+	Type        string
+	DeleteLocal bool `mapstructure:"delete_local"`
+	Options     map[string]string
+}
+
+// New opens the storage c describes.
+func New(c Config) (da.Storage, error) {
+	switch strings.ToLower(c.Type) {
+	case "local", "":
+		return NewLocal(c.Options["root"])
+	case "s3":
+		return NewS3(c.Options)
+	case "webdav":
+		return NewWebDAV(c.Options)
+	case "sftp":
+		return NewSFTP(c.Options)
+	}
+	return nil, ErrUnknownType
+}
+
+// require checks that opts sets every key in keys.
+func require(opts map[string]string, keys ...string) error {
+	for _, k := range keys {
+		if opts[k] == "" {
+			return fmt.Errorf("%s: %s", ErrMissing, k)
+		}
+	}
+	return nil
+}
+
+// join builds the remote path of key under root. The key is cleaned on its
+// own first so that .. in it cannot climb out of root.
+func join(root, key string) string {
+	return path.Join("/", root, path.Join("/", key))
+}
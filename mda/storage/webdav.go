@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/will7200/mda/da"
+)
+
+// webdav uploads with PUT, creating collections with MKCOL as needed.
+type webdav struct {
+	base     *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewWebDAV returns a Storage uploading below the collection at url.
+func NewWebDAV(opts map[string]string) (da.Storage, error) {
+	if err := require(opts, "url"); err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(strings.TrimSuffix(opts["url"], "/"))
+	if err != nil {
+		return nil, err
+	}
+	return &webdav{base: base, username: opts["username"], password: opts["password"], client: http.DefaultClient}, nil
+}
+
+func (w *webdav) url(p string) string {
+	u := *w.base
+	u.Path = path.Join(u.Path, p)
+	return u.String()
+}
+
+func (w *webdav) do(ctx context.Context, method, p string, body *os.File, ok ...int) error {
+	req, err := http.NewRequest(method, w.url(p), nil)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		fi, err := body.Stat()
+		if err != nil {
+			return err
+		}
+		req.Body, req.ContentLength = body, fi.Size()
+	}
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	return fmt.Errorf("%s %s: %s", method, w.url(p), resp.Status)
+}
+
+func (w *webdav) Put(ctx context.Context, key, file string) (string, error) {
+	dir := path.Dir(join("", key))
+	var parent string
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		parent = path.Join(parent, part)
+		// 405 is returned for collections that already exist.
+		if err := w.do(ctx, "MKCOL", parent+"/", nil, http.StatusMethodNotAllowed); err != nil {
+			return "", err
+		}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := w.do(ctx, "PUT", key, f); err != nil {
+		return "", err
+	}
+	return w.url(key), nil
+}
+
+func (w *webdav) Delete(ctx context.Context, key string) error {
+	return w.do(ctx, "DELETE", key, nil, http.StatusNotFound)
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	gormContextKey = "tracing:context"
+	gormSpanKey    = "tracing:span"
+)
+
+// DB returns db bound to ctx so the callbacks installed by RegisterCallbacks
+// parent their spans on it.
+func DB(ctx context.Context, db *gorm.DB) *gorm.DB {
+	return db.Set(gormContextKey, ctx)
+}
+
+// RegisterCallbacks wraps every gorm create, query, update and delete in a
+// span.
+func RegisterCallbacks(db *gorm.DB) {
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("tracing:before_create", before("gorm.create"))
+	cb.Create().After("gorm:create").Register("tracing:after_create", after)
+	cb.Query().Before("gorm:query").Register("tracing:before_query", before("gorm.query"))
+	cb.Query().After("gorm:query").Register("tracing:after_query", after)
+	cb.Update().Before("gorm:update").Register("tracing:before_update", before("gorm.update"))
+	cb.Update().After("gorm:update").Register("tracing:after_update", after)
+	cb.Delete().Before("gorm:delete").Register("tracing:before_delete", before("gorm.delete"))
+	cb.Delete().After("gorm:delete").Register("tracing:after_delete", after)
+	cb.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", before("gorm.row_query"))
+	cb.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", after)
+}
+
+func before(name string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		ctx := context.Background()
+		if v, ok := scope.Get(gormContextKey); ok {
+			if c, ok := v.(context.Context); ok {
+				ctx = c
+			}
+		}
+		_, span := Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("db.table", scope.TableName())))
+		scope.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func after(scope *gorm.Scope) {
+	v, ok := scope.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span, ok := v.(trace.Span)
+	if !ok {
+		return
+	}
+	span.SetAttributes(attribute.String("db.statement", scope.SQL))
+	if scope.HasError() {
+		span.RecordError(scope.DB().Error)
+		span.SetStatus(codes.Error, scope.DB().Error.Error())
+	}
+	span.End()
+}
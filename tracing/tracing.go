@@ -0,0 +1,84 @@
+// Package tracing carries request ids and OpenTelemetry spans through mda.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	uuid "github.com/satori/go.uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/will7200/mda"
+
+type contextKey int
+
+const requestIDContextKey contextKey = iota
+
+// Tracer returns the tracer every span in mda is started from.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Setup installs the global tracer provider. exporter is one of "none",
+// "stdout" or "file"; "file" appends one JSON encoded span per line to path.
+// The returned func flushes and stops the exporter.
+func Setup(exporter, path string) (func(context.Context) error, error) {
+	var opts []stdouttrace.Option
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		opts = append(opts, stdouttrace.WithPrettyPrint())
+	case "file":
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, use none, stdout or file", exporter)
+	}
+	exp, err := stdouttrace.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "mda"))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// NewRequestID returns a fresh request id.
+func NewRequestID() string {
+	return uuid.NewV4().String()
+}
+
+// WithRequestID returns a context carrying id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// RequestID returns the request id of ctx or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// Detach returns a background context that keeps the request id and span of
+// ctx, for work that outlives the request.
+func Detach(ctx context.Context) context.Context {
+	d := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+	if id := RequestID(ctx); id != "" {
+		d = WithRequestID(d, id)
+	}
+	return d
+}